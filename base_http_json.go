@@ -0,0 +1,443 @@
+// base_http_json.go
+package gobase
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	mux "github.com/gorilla/mux"
+)
+
+const (
+	JSON_CONTENT_TYPE       = "application/json; charset=utf-8"
+	DEFAULT_JSON_BODY_LIMIT = 1 << 20 //unit: byte
+)
+
+//JSON handler 返回的错误，Status 为 0 时为 500；handler 返回其他错误时转换为 HttpError
+type HttpError struct {
+	Status  int      `json:"-"`
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+	Err     error    `json:"-"`
+}
+
+//Code 由 status 的 StatusText 生成，例如 404 为 "not_found"
+func NewHttpError(status int, message string) *HttpError {
+	return &HttpError{Status: status, Code: httpErrorCode(status), Message: message}
+}
+
+func httpErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
+func (e *HttpError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %s: %v", e.Status, e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (e *HttpError) Unwrap() error {
+	return e.Err
+}
+
+//不是 HttpError 的错误：请求体超过限制为 413，ctx 超时为 504，其他为 500 且不返回错误内容
+func toHttpError(err error) *HttpError {
+	var he *HttpError
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.As(err, &he):
+		if he.Status == 0 || he.Code == "" {
+			copied := *he
+			if copied.Status == 0 {
+				copied.Status = http.StatusInternalServerError
+			}
+			if copied.Code == "" {
+				copied.Code = httpErrorCode(copied.Status)
+			}
+			he = &copied
+		}
+		return he
+	case errors.As(err, &maxBytes):
+		he = NewHttpError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBytes.Limit))
+	case errors.Is(err, context.DeadlineExceeded):
+		he = NewHttpError(http.StatusGatewayTimeout, "request timed out")
+	default:
+		he = NewHttpError(http.StatusInternalServerError, "internal server error")
+	}
+	he.Err = err
+	return he
+}
+
+//实现这个接口的响应使用 StatusCode 作为状态码，例如 201
+type IHttpStatusCoder interface {
+	StatusCode() int
+}
+
+//实现这个接口的请求在 struct tag 验证通过后调用 Validate，返回的错误不是 HttpError 时为 422
+type IHttpValidator interface {
+	Validate() error
+}
+
+//用 MarshalJsonToData 编码 v 并写入响应
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) error {
+	data, err := MarshalJsonToData(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", JSON_CONTENT_TYPE)
+	w.WriteHeader(status)
+	_, err = w.Write(data)
+	return err
+}
+
+//把 err 按 toHttpError 转换后写为 JSON 错误响应：{"code": ..., "message": ..., "details": [...]}
+func WriteJSONError(w http.ResponseWriter, err error) *HttpError {
+	he := toHttpError(err)
+	WriteJSON(w, he.Status, he)
+	return he
+}
+
+//把 fn 转换为 http.HandlerFunc：
+//请求体用 UnmarshalJsonFromData 解码到 Req，再把 `query:"name"` 和 `path:"name"` 字段设置为查询参数和 mux 路径变量，
+//按 `validate:"..."` 验证后调用 fn，Resp 为 nil 时返回 204，否则编码为 JSON；
+//请求体最多读取 DEFAULT_JSON_BODY_LIMIT 字节，超过时返回 413，经过 BodyLimitMiddleware 时以它的限制为准
+func JSONHandler[Req any, Resp any](fn func(ctx context.Context, req *Req) (*Resp, error)) http.HandlerFunc {
+	return newJSONHandler(fn, nil)
+}
+
+//在 s 上注册 JSONHandler，5xx 错误通过 s.Logger 记录
+func HandleJSON[Req any, Resp any](s *BaseHttpServer, pattern string, fn func(ctx context.Context, req *Req) (*Resp, error), middlewares ...Middleware) *mux.Route {
+	return s.HandleFunc(pattern, newJSONHandler(fn, s.Logger), middlewares...)
+}
+
+func newJSONHandler[Req any, Resp any](fn func(ctx context.Context, req *Req) (*Resp, error), logger Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fail := func(err error) {
+			he := WriteJSONError(w, err)
+			if he.Status >= http.StatusInternalServerError {
+				loggerOrNop(logger).Log(LOG_LEVEL_ERROR, "http json handler failed", "method", r.Method, "path", r.URL.Path, "status", he.Status, "err", err)
+			}
+		}
+		req := new(Req)
+		limitJSONBody(w, r)
+		if err := decodeJSONRequest(r, req); err != nil {
+			fail(err)
+			return
+		}
+		if err := ValidateStruct(req); err != nil {
+			fail(err)
+			return
+		}
+		resp, err := fn(r.Context(), req)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		status := http.StatusOK
+		if coder, ok := any(resp).(IHttpStatusCoder); ok {
+			status = coder.StatusCode()
+		}
+		if err := WriteJSON(w, status, resp); err != nil {
+			loggerOrNop(logger).Log(LOG_LEVEL_ERROR, "http json handler encode failed", "method", r.Method, "path", r.URL.Path, "err", err)
+		}
+	}
+}
+
+func limitJSONBody(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	limit, ok := r.Context().Value(bodyLimitKey{}).(int64)
+	if !ok {
+		limit = DEFAULT_JSON_BODY_LIMIT
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+}
+
+//先解码请求体，查询参数和路径变量覆盖请求体中的同名字段
+func decodeJSONRequest(r *http.Request, req interface{}) error {
+	if r.Body != nil && r.Body != http.NoBody {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			if contentType := r.Header.Get("Content-Type"); contentType != "" {
+				if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+					return NewHttpError(http.StatusUnsupportedMediaType, "content type must be application/json")
+				}
+			}
+			if err := UnmarshalJsonFromData(data, req); err != nil {
+				he := NewHttpError(http.StatusBadRequest, "invalid json body")
+				he.Details = []string{err.Error()}
+				return he
+			}
+		}
+	}
+	v := reflect.ValueOf(req).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	if err := bindHttpValues(v, "query", r.URL.Query()); err != nil {
+		return err
+	}
+	vars := mux.Vars(r)
+	path := make(map[string][]string, len(vars))
+	for k, value := range vars {
+		path[k] = []string{value}
+	}
+	return bindHttpValues(v, "path", path)
+}
+
+func bindHttpValues(v reflect.Value, tag string, values map[string][]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindHttpValues(v.Field(i), tag, values); err != nil {
+				return err
+			}
+			continue
+		}
+		name := field.Tag.Get(tag)
+		if name == "" || name == "-" {
+			continue
+		}
+		if value, ok := values[name]; ok && len(value) > 0 {
+			if err := setFieldFromStrings(v.Field(i), value); err != nil {
+				he := NewHttpError(http.StatusBadRequest, "invalid "+tag+" parameter")
+				he.Details = []string{name + ": " + err.Error()}
+				return he
+			}
+		}
+	}
+	return nil
+}
+
+func setFieldFromStrings(field reflect.Value, values []string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(values[0]))
+	}
+	switch field.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := setFieldFromStrings(elem.Elem(), values); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setFieldFromStrings(slice.Index(i), []string{value}); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setFieldFromString(field, values[0])
+}
+
+func setFieldFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+//按 `validate:"..."` 验证 struct 字段，规则用逗号分隔：
+//required 不能为零值；min=n/max=n 对数字比较值，对 string/slice/map 比较长度；oneof=a b c 必须是其中之一。
+//嵌套的 struct 递归验证，通过后调用 IHttpValidator；验证失败返回 422 的 HttpError
+func ValidateStruct(v interface{}) error {
+	var details []string
+	value := reflect.ValueOf(v)
+	if err := validateValue(value, "", &details); err != nil {
+		return err
+	}
+	if len(details) > 0 {
+		he := NewHttpError(http.StatusUnprocessableEntity, "validation failed")
+		he.Details = details
+		return he
+	}
+	if validator, ok := v.(IHttpValidator); ok {
+		if err := validator.Validate(); err != nil {
+			var he *HttpError
+			if errors.As(err, &he) {
+				return err
+			}
+			return NewHttpError(http.StatusUnprocessableEntity, err.Error())
+		}
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, prefix string, details *[]string) error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + jsonFieldName(field)
+		fv := v.Field(i)
+		if rules := field.Tag.Get("validate"); rules != "" && rules != "-" {
+			if err := validateField(fv, name, rules, details); err != nil {
+				return err
+			}
+		}
+		if field.Anonymous {
+			name = prefix
+		} else {
+			name += "."
+		}
+		if err := validateValue(fv, name, details); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//tag 写错时返回错误，验证失败时添加到 details
+func validateField(v reflect.Value, name string, rules string, details *[]string) error {
+	for _, rule := range strings.Split(rules, ",") {
+		key, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if key == "required" {
+			if v.IsZero() {
+				*details = append(*details, name+": is required")
+				return nil
+			}
+			continue
+		}
+		value := v
+		for value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		switch key {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return fmt.Errorf("invalid validate rule %q on %s: %w", rule, name, err)
+			}
+			n, length, ok := validateMeasure(value)
+			if !ok {
+				return fmt.Errorf("validate rule %q not supported on %s of type %s", rule, name, value.Type())
+			}
+			if key == "min" && n < limit || key == "max" && n > limit {
+				what := "be"
+				if length {
+					what = "have length"
+				}
+				if key == "min" {
+					*details = append(*details, fmt.Sprintf("%s: must %s at least %s", name, what, param))
+				} else {
+					*details = append(*details, fmt.Sprintf("%s: must %s at most %s", name, what, param))
+				}
+			}
+		case "oneof":
+			options := strings.Fields(param)
+			current := fmt.Sprint(value.Interface())
+			found := false
+			for _, option := range options {
+				if option == current {
+					found = true
+					break
+				}
+			}
+			if !found {
+				*details = append(*details, fmt.Sprintf("%s: must be one of [%s]", name, strings.Join(options, " ")))
+			}
+		default:
+			return fmt.Errorf("unknown validate rule %q on %s", rule, name)
+		}
+	}
+	return nil
+}
+
+//数字返回值，string/slice/map/array 返回长度
+func validateMeasure(v reflect.Value) (float64, bool, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
@@ -0,0 +1,214 @@
+// base_http_json_test.go
+package gobase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testUserRequest struct {
+	ID    int64    `path:"id" json:"-"`
+	Name  string   `json:"name" validate:"required,max=8"`
+	Age   int      `json:"age" validate:"min=1,max=150"`
+	Role  string   `json:"role" validate:"oneof=admin user"`
+	Tags  []string `query:"tag" json:"tags" validate:"max=2"`
+	Limit *int     `query:"limit" json:"-"`
+}
+
+type testUserResponse struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags"`
+	Limit   int      `json:"limit"`
+	created bool
+}
+
+func (r *testUserResponse) StatusCode() int {
+	if r.created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+func newJSONTestServer() *BaseHttpServer {
+	s := &BaseHttpServer{}
+	HandleJSON(s, "/users/{id}", func(ctx context.Context, req *testUserRequest) (*testUserResponse, error) {
+		switch req.Name {
+		case "missing":
+			return nil, NewHttpError(http.StatusNotFound, "user not found")
+		case "boom":
+			return nil, errors.New("database password is hunter2")
+		case "empty":
+			return nil, nil
+		}
+		resp := &testUserResponse{ID: req.ID, Name: req.Name, Tags: req.Tags, created: req.Role == "admin"}
+		if req.Limit != nil {
+			resp.Limit = *req.Limit
+		}
+		return resp, nil
+	})
+	return s
+}
+
+func serveJSON(s *BaseHttpServer, method string, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_JSONHandlerDecodesAndEncodes(t *testing.T) {
+	s := newJSONTestServer()
+	rec := serveJSON(s, http.MethodPost, "/users/42?tag=a&tag=b&limit=10", `{"name":"bob","age":30,"role":"admin"}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Content-Type") != JSON_CONTENT_TYPE {
+		t.Fatalf("unexpected response %d %q %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	var resp testUserResponse
+	if err := UnmarshalJsonFromData(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 42 || resp.Name != "bob" || strings.Join(resp.Tags, ",") != "a,b" || resp.Limit != 10 {
+		t.Fatalf("unexpected body %+v", resp)
+	}
+
+	rec = serveJSON(s, http.MethodPost, "/users/1", `{"name":"bob","age":30,"role":"user"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d %s", rec.Code, rec.Body)
+	}
+	rec = serveJSON(s, http.MethodPost, "/users/1", `{"name":"empty","age":30,"role":"user"}`)
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Fatalf("expect 204, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func Test_JSONHandlerErrors(t *testing.T) {
+	s := newJSONTestServer()
+	for _, c := range []struct {
+		target  string
+		body    string
+		status  int
+		code    string
+		details int
+	}{
+		{"/users/1", `{"name":`, http.StatusBadRequest, "bad_request", 1},
+		{"/users/x", `{"name":"bob","age":1,"role":"user"}`, http.StatusBadRequest, "bad_request", 1},
+		{"/users/1?limit=many", `{"name":"bob","age":1,"role":"user"}`, http.StatusBadRequest, "bad_request", 1},
+		{"/users/1?tag=a&tag=b&tag=c", `{"age":0,"role":"guest"}`, http.StatusUnprocessableEntity, "unprocessable_entity", 4},
+		{"/users/1", `{"name":"missing","age":1,"role":"user"}`, http.StatusNotFound, "not_found", 0},
+		{"/users/1", `{"name":"boom","age":1,"role":"user"}`, http.StatusInternalServerError, "internal_server_error", 0},
+	} {
+		rec := serveJSON(s, http.MethodPost, c.target, c.body)
+		var he HttpError
+		if err := json.Unmarshal(rec.Body.Bytes(), &he); err != nil {
+			t.Fatalf("%s %s: %v", c.target, c.body, err)
+		}
+		if rec.Code != c.status || he.Code != c.code || len(he.Details) != c.details || he.Message == "" {
+			t.Fatalf("%s %s: unexpected %d %s", c.target, c.body, rec.Code, rec.Body)
+		}
+		if strings.Contains(rec.Body.String(), "hunter2") {
+			t.Fatalf("internal error leaked: %s", rec.Body)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/users/1", strings.NewReader("name=bob"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expect 415, got %d", rec.Code)
+	}
+
+	limited := &BaseHttpServer{}
+	limited.Use(BodyLimitMiddleware(8))
+	HandleJSON(limited, "/", func(ctx context.Context, req *testUserRequest) (*testUserResponse, error) {
+		return nil, nil
+	})
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"bob","age":30}`))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func Test_JSONHandlerDefaultBodyLimit(t *testing.T) {
+	body := `{"name":"` + strings.Repeat("x", DEFAULT_JSON_BODY_LIMIT) + `"}`
+	//没有 BodyLimitMiddleware 时使用 DEFAULT_JSON_BODY_LIMIT
+	req := httptest.NewRequest(http.MethodPost, "/users/1", strings.NewReader(body))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	newJSONTestServer().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413, got %d %s", rec.Code, rec.Body)
+	}
+
+	//BodyLimitMiddleware 可以放宽限制，请求体被完整读取后按验证规则返回 422
+	s := newJSONTestServer()
+	s.Use(BodyLimitMiddleware(2 * DEFAULT_JSON_BODY_LIMIT))
+	rec = serveJSON(s, http.MethodPost, "/users/1", body)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expect 422, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+type testRangeRequest struct {
+	From int `query:"from"`
+	To   int `query:"to"`
+}
+
+func (r *testRangeRequest) Validate() error {
+	if r.From > r.To {
+		return errors.New("from must not be after to")
+	}
+	return nil
+}
+
+func Test_ValidateStruct(t *testing.T) {
+	if err := ValidateStruct(&testRangeRequest{From: 1, To: 2}); err != nil {
+		t.Fatal(err)
+	}
+	var he *HttpError
+	if err := ValidateStruct(&testRangeRequest{From: 3, To: 2}); !errors.As(err, &he) || he.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("expect 422, got %v", err)
+	}
+
+	type inner struct {
+		Code string `json:"code" validate:"required"`
+	}
+	type outer struct {
+		Inner inner  `json:"inner"`
+		Ptr   *inner `json:"ptr"`
+	}
+	err := ValidateStruct(&outer{Ptr: &inner{}})
+	if !errors.As(err, &he) || strings.Join(he.Details, ";") != "inner.code: is required;ptr.code: is required" {
+		t.Fatalf("unexpected nested validation %v", err)
+	}
+
+	type bad struct {
+		Name string `validate:"length=3"`
+	}
+	if err := ValidateStruct(&bad{}); err == nil || errors.As(err, &he) {
+		t.Fatalf("unknown rule should be a plain error, got %v", err)
+	}
+}
+
+func Test_JSONHandlerWithRouter(t *testing.T) {
+	r := NewRouter()
+	r.GET("/range", JSONHandler(func(ctx context.Context, req *testRangeRequest) (*testRangeRequest, error) {
+		return req, nil
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/range?from=1&to=5", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	var resp testRangeRequest
+	if err := UnmarshalJsonFromData(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || resp.From != 1 || resp.To != 5 {
+		t.Fatalf("unexpected response %d %s %v", rec.Code, rec.Body, err)
+	}
+}
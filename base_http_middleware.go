@@ -0,0 +1,361 @@
+// base_http_middleware.go
+package gobase
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mux "github.com/gorilla/mux"
+)
+
+const (
+	DEFAULT_REQUEST_ID_HEADER = "X-Request-ID"
+	DEFAULT_CORS_MAX_AGE      = 600 //unit: second
+)
+
+//和 mux.MiddlewareFunc 相同，可以直接传给 mux.Router.Use
+type Middleware func(http.Handler) http.Handler
+
+//第一个 middleware 在最外层
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+//作用于所有请求（包括没有匹配到路由的），在 Start 之前调用
+func (s *BaseHttpServer) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+//prefix 下的子路由，middlewares 只作用于子路由中匹配到的请求
+func (s *BaseHttpServer) Subrouter(prefix string, middlewares ...Middleware) *mux.Router {
+	s.checkRouter()
+	return s.router.Group(prefix, middlewares...).Router
+}
+
+//记录状态码和响应字节数
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	if r, ok := w.(*responseRecorder); ok {
+		return r
+	}
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+//http.ResponseController 通过 Unwrap 找到原始的 ResponseWriter
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+//handler panic 时记录日志和堆栈，还没有写响应时返回 500；http.ErrAbortHandler 继续向上抛出
+func RecoverMiddleware(logger Logger) Middleware {
+	log := loggerOrNop(logger)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newResponseRecorder(w)
+			defer func() {
+				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					log.Log(LOG_LEVEL_ERROR, "http handler panic", "method", r.Method, "uri", r.RequestURI,
+						"request_id", RequestIDFromContext(r.Context()), "err", err, "stack", string(debug.Stack()))
+					if rec.status == 0 {
+						http.Error(rec, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					}
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+type requestIDKey struct{}
+
+//AccessLogMiddleware 放到 context 中，RequestIDMiddleware 在内层时把 request id 写回
+type requestIDSlotKey struct{}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//请求中带了合法的 header 时沿用，否则生成新的；写到响应头和 request 的 context 中。header 为空时为 DEFAULT_REQUEST_ID_HEADER
+func RequestIDMiddleware(header string) Middleware {
+	if header == "" {
+		header = DEFAULT_REQUEST_ID_HEADER
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if !validRequestID(id) {
+				id = newRequestID()
+				r.Header.Set(header, id)
+			}
+			w.Header().Set(header, id)
+			if slot, ok := r.Context().Value(requestIDSlotKey{}).(*string); ok {
+				*slot = id
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+//防止日志注入，只接受可打印 ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+type AccessLogFormat int
+
+const (
+	ACCESS_LOG_COMMON AccessLogFormat = iota //Common Log Format
+	ACCESS_LOG_JSON                          //每行一个 JSON 对象
+)
+
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	Remote    string  `json:"remote"`
+	User      string  `json:"user,omitempty"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"duration_ms"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+//每个请求结束后向 out 写一行
+func AccessLogMiddleware(out io.Writer, format AccessLogFormat) Middleware {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newResponseRecorder(w)
+			//RequestIDMiddleware 在外层时从 context 读取，在内层时写回 slot，不依赖 header 的名字
+			slot := new(string)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDSlotKey{}, slot))
+			defer func() {
+				requestID := RequestIDFromContext(r.Context())
+				if requestID == "" {
+					requestID = *slot
+				}
+				host, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					host = r.RemoteAddr
+				}
+				user := "-"
+				if r.URL.User != nil && r.URL.User.Username() != "" {
+					user = r.URL.User.Username()
+				} else if name, _, ok := r.BasicAuth(); ok && name != "" {
+					user = name
+				}
+				var line []byte
+				if format == ACCESS_LOG_JSON {
+					entry := accessLogEntry{
+						Time:      start.Format(time.RFC3339Nano),
+						Remote:    host,
+						Method:    r.Method,
+						URI:       r.RequestURI,
+						Proto:     r.Proto,
+						Status:    rec.Status(),
+						Bytes:     rec.bytes,
+						Duration:  float64(time.Since(start).Microseconds()) / 1000,
+						RequestID: requestID,
+					}
+					if user != "-" {
+						entry.User = user
+					}
+					line, _ = json.Marshal(entry)
+					line = append(line, '\n')
+				} else {
+					line = []byte(fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s\n", host, user,
+						start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.RequestURI, r.Proto,
+						rec.Status(), commonLogBytes(rec.bytes)))
+				}
+				mu.Lock()
+				out.Write(line)
+				mu.Unlock()
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+func commonLogBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+type CORSOptions struct {
+	AllowedOrigins   []string //"*" 表示所有来源，为空时不允许跨域
+	AllowedMethods   []string //为空时为 GET、POST、HEAD
+	AllowedHeaders   []string //为空时回显预检请求的 Access-Control-Request-Headers
+	ExposedHeaders   []string
+	//只对 AllowedOrigins 中明确列出的来源生效，只匹配 "*" 的来源不带凭证，否则任意网站都能带着 cookie 跨域访问
+	AllowCredentials bool
+	MaxAge           time.Duration //预检结果的缓存时间，unit: second，0 时为 DEFAULT_CORS_MAX_AGE
+}
+
+//explicit 表示 origin 在 AllowedOrigins 中明确列出，而不是只匹配 "*"
+func (o *CORSOptions) allowOrigin(origin string) (allowed bool, explicit bool) {
+	for _, a := range o.AllowedOrigins {
+		if strings.EqualFold(a, origin) {
+			return true, true
+		}
+		if a == "*" {
+			allowed = true
+		}
+	}
+	return allowed, false
+}
+
+//预检请求直接返回 204，不会到达 handler
+func CORSMiddleware(options CORSOptions) Middleware {
+	methods := options.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodHead}
+	}
+	maxAge := options.MaxAge
+	if maxAge <= 0 {
+		maxAge = DEFAULT_CORS_MAX_AGE
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			allowed, explicit := options.allowOrigin(origin)
+			if origin == "" || !allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			//带凭证时不能返回 "*"，只匹配 "*" 的来源不带凭证
+			credentials := options.AllowCredentials && explicit
+			if explicit {
+				h.Set("Access-Control-Allow-Origin", origin)
+			} else {
+				h.Set("Access-Control-Allow-Origin", "*")
+			}
+			if credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				if len(options.AllowedHeaders) > 0 {
+					h.Set("Access-Control-Allow-Headers", strings.Join(options.AllowedHeaders, ", "))
+				} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", reqHeaders)
+				}
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge)))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if len(options.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//handler 超过 timeOut 没有完成时返回 503，request 的 context 同时被取消；timeOut unit: second
+//响应在 handler 完成前被缓冲，不适用于流式响应
+func TimeoutMiddleware(timeOut time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, timeOut*time.Second, http.StatusText(http.StatusServiceUnavailable))
+	}
+}
+
+type bodyLimitKey struct{}
+
+//Content-Length 超过 maxBytes 时直接返回 413，否则读取 body 超过 maxBytes 时返回 *http.MaxBytesError；
+//之后的 JSONHandler 以 maxBytes 代替 DEFAULT_JSON_BODY_LIMIT
+func BodyLimitMiddleware(maxBytes int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, maxBytes)))
+		})
+	}
+}
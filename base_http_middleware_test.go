@@ -0,0 +1,219 @@
+// base_http_middleware_test.go
+package gobase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_MiddlewareChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	s := &BaseHttpServer{}
+	s.Use(mark("global"))
+	api := s.Subrouter("/api", mark("api"))
+	api.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	})
+	s.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}, mark("route1"), mark("route2"))
+	url := startHttpServer(t, s)
+	defer s.Stop()
+
+	for _, c := range []struct {
+		path string
+		want string
+	}{
+		{"/api/users", "global,api,handler"},
+		{"/health", "global,route1,route2,handler"},
+		{"/missing", "global"},
+	} {
+		order = nil
+		resp, err := http.Get(url + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := strings.Join(order, ","); got != c.want {
+			t.Fatalf("%s: unexpected order %s, want %s", c.path, got, c.want)
+		}
+	}
+}
+
+func Test_RecoverAndRequestID(t *testing.T) {
+	var logged bytes.Buffer
+	handler := Chain(
+		AccessLogMiddleware(&logged, ACCESS_LOG_JSON),
+		RecoverMiddleware(nil),
+		RequestIDMiddleware(""),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestIDFromContext(r.Context()) == "" {
+			t.Error("request id missing from context")
+		}
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("X-Request-ID") != "abc-123" {
+		t.Fatalf("unexpected response %d, request id %q", rec.Code, rec.Header().Get("X-Request-ID"))
+	}
+	var entry accessLogEntry
+	if err := json.Unmarshal(logged.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != 500 || entry.RequestID != "abc-123" || entry.URI != "/panic" {
+		t.Fatalf("unexpected access log %+v", entry)
+	}
+
+	//非法的 request id 被替换
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "bad\nid")
+	rec = httptest.NewRecorder()
+	RequestIDMiddleware("")(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if id := rec.Header().Get("X-Request-ID"); len(id) != 32 {
+		t.Fatalf("unexpected generated request id %q", id)
+	}
+}
+
+func Test_AccessLogCustomRequestIDHeader(t *testing.T) {
+	//RequestIDMiddleware 使用自定义 header，在 AccessLogMiddleware 内层和外层时都能记录 request id
+	for _, inner := range []bool{true, false} {
+		var logged bytes.Buffer
+		accessLog, requestID := AccessLogMiddleware(&logged, ACCESS_LOG_JSON), RequestIDMiddleware("X-Trace-ID")
+		handler := Chain(requestID, accessLog)(http.NotFoundHandler())
+		if inner {
+			handler = Chain(accessLog, requestID)(http.NotFoundHandler())
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Trace-ID", "trace-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		var entry accessLogEntry
+		if err := json.Unmarshal(logged.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.RequestID != "trace-1" {
+			t.Fatalf("inner %v: unexpected request id %q", inner, entry.RequestID)
+		}
+	}
+}
+
+func Test_AccessLogCommon(t *testing.T) {
+	var logged bytes.Buffer
+	handler := AccessLogMiddleware(&logged, ACCESS_LOG_COMMON)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/items?id=1", nil)
+	req.SetBasicAuth("frank", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	line := logged.String()
+	if !strings.HasPrefix(line, "192.0.2.1 - frank [") || !strings.HasSuffix(line, "] \"POST /items?id=1 HTTP/1.1\" 201 5\n") {
+		t.Fatalf("unexpected common log line %q", line)
+	}
+}
+
+func Test_CORSMiddleware(t *testing.T) {
+	called := false
+	handler := CORSMiddleware(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPut},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"X-Request-ID"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	h := rec.Header()
+	if called || rec.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Methods") != "GET, PUT" || h.Get("Access-Control-Allow-Headers") != "Content-Type" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("unexpected preflight response %d %v", rec.Code, h)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("disallowed origin should not get CORS headers: %v", rec.Header())
+	}
+}
+
+func Test_CORSWildcardWithoutCredentials(t *testing.T) {
+	handler := CORSMiddleware(CORSOptions{
+		AllowedOrigins:   []string{"*", "https://app.example.com"},
+		AllowCredentials: true,
+	})(http.NotFoundHandler())
+	for _, c := range []struct {
+		origin      string
+		allow       string
+		credentials string
+	}{
+		{"https://app.example.com", "https://app.example.com", "true"},
+		//只匹配 "*" 的来源不带凭证
+		{"https://evil.example.com", "*", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Origin", c.origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if h := rec.Header(); h.Get("Access-Control-Allow-Origin") != c.allow || h.Get("Access-Control-Allow-Credentials") != c.credentials {
+			t.Fatalf("origin %s: unexpected headers %v", c.origin, h)
+		}
+	}
+}
+
+func Test_TimeoutAndBodyLimit(t *testing.T) {
+	slow := TimeoutMiddleware(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	rec := httptest.NewRecorder()
+	slow.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expect 503, got %d", rec.Code)
+	}
+
+	var readErr error
+	limited := BodyLimitMiddleware(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789")))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("0123456789")))
+	req.ContentLength = -1
+	limited.ServeHTTP(httptest.NewRecorder(), req)
+	var maxErr *http.MaxBytesError
+	if readErr == nil || !errors.As(readErr, &maxErr) {
+		t.Fatalf("expect MaxBytesError, got %v", readErr)
+	}
+}
@@ -0,0 +1,145 @@
+// base_http_router.go
+package gobase
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	mux "github.com/gorilla/mux"
+)
+
+var ErrRouteNotFound = errors.New("route not found")
+
+//*mux.Router 的方法都可以直接使用；Group 返回的 Router 共享同一个路由表
+type Router struct {
+	*mux.Router
+	prefix      string       //Group 的完整前缀，Mount 时需要去掉
+	middlewares []Middleware //With 设置的，只作用于通过这个 Router 注册的路由
+}
+
+type RouteInfo struct {
+	Name    string
+	Path    string   //路径模板，例如 "/users/{id}"
+	Methods []string //为空表示不限制方法
+}
+
+func NewRouter() *Router {
+	return &Router{Router: mux.NewRouter()}
+}
+
+//prefix 下的路由组，middlewares 作用于组内匹配到的请求
+func (r *Router) Group(prefix string, middlewares ...Middleware) *Router {
+	sub := r.Router.PathPrefix(prefix).Subrouter()
+	for _, m := range middlewares {
+		sub.Use(mux.MiddlewareFunc(m))
+	}
+	return &Router{Router: sub, prefix: r.prefix + prefix, middlewares: r.middlewares}
+}
+
+//返回的 Router 注册的路由都会先经过 middlewares，例如 r.With(auth).GET("/admin", h)
+func (r *Router) With(middlewares ...Middleware) *Router {
+	return &Router{
+		Router:      r.Router,
+		prefix:      r.prefix,
+		middlewares: append(append([]Middleware(nil), r.middlewares...), middlewares...),
+	}
+}
+
+func (r *Router) wrap(handler http.Handler) http.Handler {
+	if len(r.middlewares) == 0 {
+		return handler
+	}
+	return Chain(r.middlewares...)(handler)
+}
+
+func (r *Router) Handle(path string, handler http.Handler) *mux.Route {
+	return r.Router.Handle(path, r.wrap(handler))
+}
+
+func (r *Router) HandleFunc(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.Handle(path, http.HandlerFunc(handler))
+}
+
+func (r *Router) GET(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.HandleFunc(path, handler).Methods(http.MethodGet, http.MethodHead)
+}
+
+func (r *Router) POST(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.HandleFunc(path, handler).Methods(http.MethodPost)
+}
+
+func (r *Router) PUT(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.HandleFunc(path, handler).Methods(http.MethodPut)
+}
+
+func (r *Router) PATCH(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.HandleFunc(path, handler).Methods(http.MethodPatch)
+}
+
+func (r *Router) DELETE(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.HandleFunc(path, handler).Methods(http.MethodDelete)
+}
+
+func (r *Router) HEAD(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.HandleFunc(path, handler).Methods(http.MethodHead)
+}
+
+func (r *Router) OPTIONS(path string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return r.HandleFunc(path, handler).Methods(http.MethodOptions)
+}
+
+//prefix 下的所有请求交给 handler，handler 看到的路径去掉了 prefix（包括所在 Group 的前缀），
+//handler 可以是另一个 *BaseHttpServer
+func (r *Router) Mount(prefix string, handler http.Handler) *mux.Route {
+	prefix = strings.TrimSuffix(prefix, "/")
+	stripped := http.StripPrefix(r.prefix+prefix, handler)
+	if prefix == "" {
+		return r.Router.PathPrefix("/").Handler(r.wrap(stripped))
+	}
+	//"/prefix" 和 "/prefix/..." 都匹配，"/prefixother" 不匹配
+	full := r.prefix + prefix
+	return r.Router.PathPrefix(prefix).MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+		return req.URL.Path == full || strings.HasPrefix(req.URL.Path, full+"/")
+	}).Handler(r.wrap(stripped))
+}
+
+//按 Name 注册的路由生成 URL，pairs 为路径变量，例如 r.URL("user", "id", "42")
+func (r *Router) URL(name string, pairs ...string) (*url.URL, error) {
+	route := r.Router.Get(name)
+	if route == nil {
+		return nil, fmt.Errorf("%w: %s", ErrRouteNotFound, name)
+	}
+	return route.URL(pairs...)
+}
+
+//按注册顺序列出所有路由，包括 Group 中的
+func (r *Router) Routes() []RouteInfo {
+	var routes []RouteInfo
+	r.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		//没有 handler 的是 Group 自身
+		if route.GetHandler() == nil {
+			return nil
+		}
+		info := RouteInfo{Name: route.GetName()}
+		info.Path, _ = route.GetPathTemplate()
+		info.Methods, _ = route.GetMethods()
+		routes = append(routes, info)
+		return nil
+	})
+	return routes
+}
+
+//BaseHttpServer 作为 http.Handler 使用，经过 Use 注册的 middleware，可以 Mount 到其他 Router
+func (s *BaseHttpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	if !s.wrapped && len(s.middlewares) > 0 {
+		handler = Chain(s.middlewares...)(handler)
+	}
+	handler.ServeHTTP(w, req)
+}
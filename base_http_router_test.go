@@ -0,0 +1,104 @@
+// base_http_router_test.go
+package gobase
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func routerGet(t *testing.T, h http.Handler, method string, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+func writeText(text string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(text + Vars(r)["id"]))
+	}
+}
+
+func headerMiddleware(key string, value string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add(key, value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func Test_ServerRouter(t *testing.T) {
+	s := &BaseHttpServer{}
+	r := s.Router()
+	if r == nil || s.Router() != r {
+		t.Fatal("Router should return the same wrapper")
+	}
+	r.GET("/users/{id}", writeText("get ")).Name("user")
+	r.POST("/users", writeText("post"))
+
+	api := r.Group("/api/v1", headerMiddleware("X-Group", "v1"))
+	api.DELETE("/items/{id}", writeText("delete ")).Name("item")
+	api.With(headerMiddleware("X-Route", "admin")).GET("/admin", writeText("admin"))
+
+	if code, body := routerGet(t, s, http.MethodGet, "/users/7"); code != 200 || body != "get 7" {
+		t.Fatalf("unexpected response %d %q", code, body)
+	}
+	if code, _ := routerGet(t, s, http.MethodPut, "/users/7"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect 405, got %d", code)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin", nil))
+	if rec.Header().Get("X-Group") != "v1" || rec.Header().Get("X-Route") != "admin" {
+		t.Fatalf("group/route middleware not applied: %v", rec.Header())
+	}
+
+	u, err := r.URL("item", "id", "42")
+	if err != nil || u.Path != "/api/v1/items/42" {
+		t.Fatalf("unexpected url %v, err %v", u, err)
+	}
+	if _, err := r.URL("missing"); err == nil {
+		t.Fatal("expect error for unknown route")
+	}
+
+	want := []RouteInfo{
+		{Name: "user", Path: "/users/{id}", Methods: []string{"GET", "HEAD"}},
+		{Path: "/users", Methods: []string{"POST"}},
+		{Name: "item", Path: "/api/v1/items/{id}", Methods: []string{"DELETE"}},
+		{Path: "/api/v1/admin", Methods: []string{"GET", "HEAD"}},
+	}
+	if routes := r.Routes(); !reflect.DeepEqual(routes, want) {
+		t.Fatalf("unexpected routes %+v", routes)
+	}
+}
+
+func Test_RouterMount(t *testing.T) {
+	static := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static " + r.URL.Path))
+	})
+	admin := &BaseHttpServer{}
+	admin.Use(headerMiddleware("X-Admin", "yes"))
+	admin.HandleFunc("/stats", writeText("stats"))
+
+	r := NewRouter()
+	r.Mount("/static", static)
+	r.Group("/internal").Mount("/admin", admin)
+
+	if code, body := routerGet(t, r, http.MethodGet, "/static/css/app.css"); code != 200 || body != "static /css/app.css" {
+		t.Fatalf("unexpected response %d %q", code, body)
+	}
+	if code, _ := routerGet(t, r, http.MethodGet, "/staticfile"); code != http.StatusNotFound {
+		t.Fatalf("mount should not match sibling path, got %d", code)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/internal/admin/stats", nil))
+	if rec.Body.String() != "stats" || rec.Header().Get("X-Admin") != "yes" {
+		t.Fatalf("mounted server not served: %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if routes := r.Routes(); len(routes) != 2 || routes[1].Path != "/internal/admin" {
+		t.Fatalf("unexpected routes %+v", routes)
+	}
+}
@@ -0,0 +1,133 @@
+// base_http_server.go
+package gobase
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	DEFAULT_HTTP_READ_HEADER_TIMEOUT = 10  //unit: second
+	DEFAULT_HTTP_IDLE_TIMEOUT        = 120 //unit: second
+)
+
+var ErrHttpServerNotStarted = errors.New("http server not started")
+
+//在 Start 时设置到 http.Server 上，http.Server 对应字段已经设置时以 http.Server 为准
+type HttpServerOptions struct {
+	ReadTimeOut       time.Duration //读取整个请求的超时，unit: second，0 表示不限制
+	ReadHeaderTimeOut time.Duration //读取请求头的超时，unit: second，0 时为 DEFAULT_HTTP_READ_HEADER_TIMEOUT
+	WriteTimeOut      time.Duration //写响应的超时，unit: second，0 表示不限制
+	IdleTimeOut       time.Duration //keep-alive 连接的空闲超时，unit: second，0 时为 DEFAULT_HTTP_IDLE_TIMEOUT
+	//连接状态变化时回调，在 http.Server.ConnState 之后调用
+	OnConnState func(conn net.Conn, state http.ConnState)
+}
+
+func (s *BaseHttpServer) applyOptions() {
+	o := &s.Options
+	if s.ReadTimeout == 0 && o.ReadTimeOut > 0 {
+		s.ReadTimeout = o.ReadTimeOut * time.Second
+	}
+	if s.ReadHeaderTimeout == 0 {
+		s.ReadHeaderTimeout = o.ReadHeaderTimeOut * time.Second
+		if o.ReadHeaderTimeOut <= 0 {
+			s.ReadHeaderTimeout = DEFAULT_HTTP_READ_HEADER_TIMEOUT * time.Second
+		}
+	}
+	if s.WriteTimeout == 0 && o.WriteTimeOut > 0 {
+		s.WriteTimeout = o.WriteTimeOut * time.Second
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = o.IdleTimeOut * time.Second
+		if o.IdleTimeOut <= 0 {
+			s.IdleTimeout = DEFAULT_HTTP_IDLE_TIMEOUT * time.Second
+		}
+	}
+	if o.OnConnState != nil {
+		connState := s.ConnState
+		s.ConnState = func(conn net.Conn, state http.ConnState) {
+			if connState != nil {
+				connState(conn, state)
+			}
+			o.OnConnState(conn, state)
+		}
+	}
+}
+
+//在新的 goroutine 中 serve，结束后通过 Wait 和 ServeErrors 通知
+func (s *BaseHttpServer) serve(listener net.Listener, serve func(net.Listener) error) {
+	s.applyOptions()
+	if (len(s.middlewares) > 0 || s.h2c) && !s.wrapped {
+		handler := s.Handler
+		if handler == nil {
+			handler = http.DefaultServeMux
+		}
+		if len(s.middlewares) > 0 {
+			handler = Chain(s.middlewares...)(handler)
+		}
+		if s.h2c {
+			handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: s.IdleTimeout})
+		}
+		s.Handler = handler
+		s.wrapped = true
+	}
+	s.listener = listener
+	s.serveDone = make(chan struct{})
+	s.serveErrs = make(chan error, 1)
+	go func() {
+		err := serve(listener)
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		} else {
+			s.log().Log(LOG_LEVEL_WARN, "http server serve exited", "addr", listener.Addr(), "err", err)
+		}
+		s.serveErr = err
+		s.serveErrs <- err
+		close(s.serveErrs)
+		close(s.serveDone)
+	}()
+}
+
+//阻塞到 serve 结束，Shutdown/Stop 引起的结束返回 nil
+func (s *BaseHttpServer) Wait() error {
+	if s.serveDone == nil {
+		return ErrHttpServerNotStarted
+	}
+	<-s.serveDone
+	return s.serveErr
+}
+
+//serve 结束时收到一个错误（Shutdown/Stop 引起的结束为 nil），随后关闭
+func (s *BaseHttpServer) ServeErrors() <-chan error {
+	return s.serveErrs
+}
+
+//实际监听的地址，端口为 0 时由系统分配
+func (s *BaseHttpServer) ListenAddr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+//停止接受新连接，关闭空闲连接并等待正在处理的请求完成；ctx 结束时强制关闭剩余连接并返回 ctx 的错误
+func (s *BaseHttpServer) Shutdown(ctx context.Context) error {
+	if s.serveDone == nil {
+		return nil
+	}
+	s.log().Log(LOG_LEVEL_INFO, "http server shutting down", "addr", s.listener.Addr())
+	s.stopTLS(ctx)
+	err := s.Server.Shutdown(ctx)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_WARN, "http server shutdown timed out, closing connections", "addr", s.listener.Addr(), "err", err)
+		s.Server.Close()
+	}
+	<-s.serveDone
+	return err
+}
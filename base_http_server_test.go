@@ -0,0 +1,148 @@
+// base_http_server_test.go
+package gobase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startHttpServer(t *testing.T, s *BaseHttpServer) string {
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return "http://" + s.ListenAddr().String()
+}
+
+func Test_HttpServerShutdownWaitsForRequests(t *testing.T) {
+	var mu sync.Mutex
+	var states []http.ConnState
+	s := &BaseHttpServer{Options: HttpServerOptions{ReadTimeOut: 5, OnConnState: func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+	}}}
+	entered, release := make(chan struct{}), make(chan struct{})
+	s.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("done"))
+	})
+	url := startHttpServer(t, s)
+	if s.ReadTimeout != 5*time.Second || s.IdleTimeout != DEFAULT_HTTP_IDLE_TIMEOUT*time.Second {
+		t.Fatalf("options not applied, read %s idle %s", s.ReadTimeout, s.IdleTimeout)
+	}
+
+	result := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- string(body)
+	}()
+	<-entered
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before in-flight request finished, err %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if body := <-result; body != "done" {
+		t.Fatalf("in-flight request failed: %s", body)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatalf("graceful shutdown should not be a serve error, got %v", err)
+	}
+	if err, ok := <-s.ServeErrors(); err != nil || !ok {
+		t.Fatalf("unexpected serve error %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) < 3 || states[0] != http.StateNew || states[len(states)-1] != http.StateClosed {
+		t.Fatalf("unexpected conn states %v", states)
+	}
+}
+
+func Test_HttpServerShutdownTimeout(t *testing.T) {
+	s := &BaseHttpServer{}
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	url := startHttpServer(t, s)
+	go http.Get(url + "/stuck")
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected shutdown error %v", err)
+	}
+}
+
+func Test_HttpServerStopDoesNotWait(t *testing.T) {
+	s := &BaseHttpServer{}
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	url := startHttpServer(t, s)
+	go http.Get(url + "/stuck")
+	<-entered
+
+	//Stop 不等待正在处理的请求
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked on in-flight request")
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatalf("Stop should not be a serve error, got %v", err)
+	}
+	if _, err := http.Get(url + "/stuck"); err == nil {
+		t.Fatal("server still accepting after Stop")
+	}
+}
+
+func Test_HttpServerServeError(t *testing.T) {
+	s := &BaseHttpServer{}
+	startHttpServer(t, s)
+	//监听被外部关闭时 Serve 返回错误
+	s.listener.Close()
+	select {
+	case err := <-s.ServeErrors():
+		if err == nil {
+			t.Fatal("expect serve error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve error not reported")
+	}
+	if s.Wait() == nil {
+		t.Fatal("Wait should return the serve error")
+	}
+}
@@ -0,0 +1,284 @@
+// base_http_tls.go
+package gobase
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CERT_RELOAD_INTERVAL = 10 //unit: second
+)
+
+var (
+	ErrNoCertificate   = errors.New("no certificate loaded")
+	ErrInvalidClientCA = errors.New("no certificate found in client ca file")
+)
+
+//StartTLS 的可选配置
+type TLSOptions struct {
+	//检查证书文件修改时间的间隔，unit: second，0 时为 DEFAULT_CERT_RELOAD_INTERVAL，小于 0 不检查
+	ReloadInterval time.Duration
+	//收到该信号时重新加载证书，例如 syscall.SIGHUP，nil 表示不监听
+	ReloadSignal os.Signal
+	//不为空时用其中的 CA 验证客户端证书，验证通过的证书通过 ClientCertificate 获取
+	ClientCAFile string
+	//ClientCAFile 不为空且未设置时为 tls.RequireAndVerifyClientCert
+	ClientAuth tls.ClientAuthType
+	//不为空时在该地址监听 HTTP，把所有请求重定向到 HTTPS
+	RedirectAddr string
+}
+
+//通过 tls.Config.GetCertificate 提供证书，Reload 只影响之后的握手，已经建立的连接不受影响
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+}
+
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//重新读取证书和私钥，失败时继续使用旧证书
+func (r *CertReloader) Reload() error {
+	certMod, keyMod := fileModTime(r.certFile), fileModTime(r.keyFile)
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.certMod, r.keyMod = certMod, keyMod
+	r.mu.Unlock()
+	return nil
+}
+
+//证书或私钥文件修改时间变化时重新加载
+func (r *CertReloader) reloadIfChanged() (bool, error) {
+	r.mu.RLock()
+	changed := !fileModTime(r.certFile).Equal(r.certMod) || !fileModTime(r.keyFile).Equal(r.keyMod)
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, r.Reload()
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return nil, ErrNoCertificate
+	}
+	return r.cert, nil
+}
+
+//按 interval 检查文件变化，收到 sig 时强制重新加载，quit 关闭后退出
+func (r *CertReloader) watch(interval time.Duration, sig os.Signal, quit <-chan struct{}, logger Logger) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var sigs chan os.Signal
+	if sig != nil {
+		sigs = make(chan os.Signal, 1)
+		signal.Notify(sigs, sig)
+		defer signal.Stop(sigs)
+	}
+	for {
+		select {
+		case <-quit:
+			return
+		case <-tick:
+			if changed, err := r.reloadIfChanged(); err != nil {
+				logger.Log(LOG_LEVEL_WARN, "reload certificate failed", "cert", r.certFile, "err", err)
+			} else if changed {
+				logger.Log(LOG_LEVEL_INFO, "certificate reloaded", "cert", r.certFile)
+			}
+		case <-sigs:
+			if err := r.Reload(); err != nil {
+				logger.Log(LOG_LEVEL_WARN, "reload certificate failed", "cert", r.certFile, "err", err)
+			} else {
+				logger.Log(LOG_LEVEL_INFO, "certificate reloaded", "cert", r.certFile, "signal", sig)
+			}
+		}
+	}
+}
+
+func fileModTime(name string) time.Time {
+	if info, err := os.Stat(name); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+//addr: "ip:port"，certFile 和 keyFile 为 PEM 文件，启用 HTTP/2，其他配置见 TLSOptions
+func (s *BaseHttpServer) StartTLS(addr string, certFile string, keyFile string) error {
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "http server load certificate failed", "cert", certFile, "err", err)
+		return err
+	}
+	config, err := s.tlsConfig(certs)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "http server load client ca failed", "ca", s.TLS.ClientCAFile, "err", err)
+		return err
+	}
+	if addr == "" {
+		addr = ":https"
+	}
+	listener, err := s.listen(addr)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "http server bind failed", "addr", addr, "err", err)
+		return err
+	}
+	if s.TLS.RedirectAddr != "" {
+		if err := s.startRedirect(s.TLS.RedirectAddr, listener.Addr()); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	s.log().Log(LOG_LEVEL_INFO, "https server bind successed", "addr", listener.Addr())
+	s.TLSConfig = config
+	s.certs = certs
+	s.tlsQuit = make(chan struct{})
+	interval := s.TLS.ReloadInterval * time.Second
+	if s.TLS.ReloadInterval == 0 {
+		interval = DEFAULT_CERT_RELOAD_INTERVAL * time.Second
+	}
+	go certs.watch(interval, s.TLS.ReloadSignal, s.tlsQuit, s.log())
+	s.serve(listener, func(ln net.Listener) error {
+		return s.ServeTLS(ln, "", "")
+	})
+	return nil
+}
+
+//StartTLS 使用的证书，未启动 TLS 时为 nil
+func (s *BaseHttpServer) CertReloader() *CertReloader {
+	return s.certs
+}
+
+//以 http.Server.TLSConfig 为基础，证书由 certs 提供
+func (s *BaseHttpServer) tlsConfig(certs *CertReloader) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	config.GetCertificate = certs.GetCertificate
+	if s.TLS.ClientCAFile != "" {
+		data, err := os.ReadFile(s.TLS.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, ErrInvalidClientCA
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = s.TLS.ClientAuth
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
+}
+
+func (s *BaseHttpServer) startRedirect(addr string, httpsAddr net.Addr) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "http redirect server bind failed", "addr", addr, "err", err)
+		return err
+	}
+	_, port, _ := net.SplitHostPort(httpsAddr.String())
+	s.redirect = &http.Server{
+		Handler:           RedirectHTTPSHandler(port),
+		ReadHeaderTimeout: DEFAULT_HTTP_READ_HEADER_TIMEOUT * time.Second,
+		IdleTimeout:       DEFAULT_HTTP_IDLE_TIMEOUT * time.Second,
+	}
+	s.redirectAddr = listener.Addr()
+	s.log().Log(LOG_LEVEL_INFO, "http redirect server bind successed", "addr", listener.Addr(), "to", port)
+	go s.redirect.Serve(listener)
+	return nil
+}
+
+//TLSOptions.RedirectAddr 实际监听的地址，没有时为 nil
+func (s *BaseHttpServer) RedirectAddr() net.Addr {
+	return s.redirectAddr
+}
+
+//把请求以 308 重定向到同一 host 的 https 地址，port 为空或 "443" 时不带端口
+func RedirectHTTPSHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+//停止证书检查和重定向服务
+func (s *BaseHttpServer) stopTLS(ctx context.Context) {
+	if s.tlsQuit != nil {
+		close(s.tlsQuit)
+		s.tlsQuit = nil
+	}
+	if s.redirect != nil {
+		if err := s.redirect.Shutdown(ctx); err != nil {
+			s.redirect.Close()
+		}
+		s.redirect = nil
+	}
+}
+
+//经过 TLSOptions.ClientCAFile 验证的客户端证书，没有时返回 nil
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+//经过验证的客户端证书的 Subject CommonName，没有时返回 ""
+func ClientIdentity(r *http.Request) string {
+	if cert := ClientCertificate(r); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+//addr: "ip:port"，明文 HTTP/2 (h2c)，同时支持 HTTP/1.1，用于内部流量
+func (s *BaseHttpServer) StartH2C(addr string) error {
+	listener, err := s.listen(addr)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "http server bind failed", "addr", addr, "err", err)
+		return err
+	}
+	s.log().Log(LOG_LEVEL_INFO, "h2c server bind successed", "addr", listener.Addr())
+	s.h2c = true
+	s.serve(listener, s.Serve)
+	return nil
+}
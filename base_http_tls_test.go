@@ -0,0 +1,284 @@
+// base_http_tls_test.go
+package gobase
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+//parent 为 nil 时生成自签名的 CA
+func newTestCert(t *testing.T, cn string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) writeFiles(t *testing.T, dir string, name string) (string, string) {
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func newTLSClient(ca *testCert, certs ...tls.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: certs},
+		ForceAttemptHTTP2: true,
+	}}
+}
+
+func Test_HttpServerStartTLSServesHTTP2AndReloads(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil, x509.ExtKeyUsageAny)
+	certFile, keyFile := newTestCert(t, "server one", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, dir, "server")
+
+	s := &BaseHttpServer{TLS: TLSOptions{ReloadInterval: -1}}
+	s.HandleFunc("/proto", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	if err := s.StartTLS("127.0.0.1:0", certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	url := "https://" + s.ListenAddr().String()
+
+	client := newTLSClient(ca)
+	resp, err := client.Get(url + "/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+		t.Fatalf("expect HTTP/2, got %s %q", resp.Proto, body)
+	}
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "server one" {
+		t.Fatalf("unexpected certificate %q", cn)
+	}
+
+	//旧连接保持可用，新连接使用新证书
+	newTestCert(t, "server two", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, dir, "server")
+	if err := s.CertReloader().Reload(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Get(url + "/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "server one" {
+		t.Fatalf("existing connection should keep old certificate, got %q", cn)
+	}
+	resp, err = newTLSClient(ca).Get(url + "/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "server two" {
+		t.Fatalf("new connection should use reloaded certificate, got %q", cn)
+	}
+
+	//加载失败时继续使用旧证书
+	os.WriteFile(certFile, []byte("broken"), 0600)
+	if err := s.CertReloader().Reload(); err == nil {
+		t.Fatal("expect reload error")
+	}
+	resp, err = newTLSClient(ca).Get(url + "/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func Test_CertReloaderReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil, x509.ExtKeyUsageAny)
+	certFile, keyFile := newTestCert(t, "one", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, dir, "server")
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.reloadIfChanged(); changed || err != nil {
+		t.Fatalf("unchanged files reloaded, err %v", err)
+	}
+	newTestCert(t, "two", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if changed, err := r.reloadIfChanged(); !changed || err != nil {
+		t.Fatalf("changed files not reloaded, err %v", err)
+	}
+	cert, _ := r.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "two" {
+		t.Fatalf("unexpected certificate %q", leaf.Subject.CommonName)
+	}
+}
+
+func Test_HttpServerClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil, x509.ExtKeyUsageAny)
+	certFile, keyFile := newTestCert(t, "server", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, dir, "server")
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	client := newTestCert(t, "alice", ca, x509.ExtKeyUsageClientAuth)
+
+	s := &BaseHttpServer{TLS: TLSOptions{ClientCAFile: caFile}}
+	s.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ClientIdentity(r)))
+	})
+	if err := s.StartTLS("127.0.0.1:0", certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	url := "https://" + s.ListenAddr().String() + "/whoami"
+
+	resp, err := newTLSClient(ca, client.tlsCertificate()).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "alice" {
+		t.Fatalf("unexpected identity %q", body)
+	}
+	if _, err := newTLSClient(ca).Get(url); err == nil {
+		t.Fatal("request without client certificate should fail")
+	}
+}
+
+func Test_HttpServerRedirectsToHTTPS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil, x509.ExtKeyUsageAny)
+	certFile, keyFile := newTestCert(t, "server", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, dir, "server")
+
+	s := &BaseHttpServer{TLS: TLSOptions{RedirectAddr: "127.0.0.1:0"}}
+	if err := s.StartTLS("127.0.0.1:0", certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(s.ListenAddr().String())
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Post("http://"+s.RedirectAddr().String()+"/a/b?c=d", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != "https://127.0.0.1:"+port+"/a/b?c=d" {
+		t.Fatalf("unexpected redirect %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	redirectAddr := s.RedirectAddr().String()
+	s.Stop()
+	if _, err := client.Get("http://" + redirectAddr + "/"); err == nil {
+		t.Fatal("redirect server still running after Stop")
+	}
+}
+
+func Test_RedirectHTTPSHandlerDefaultPort(t *testing.T) {
+	for _, c := range []struct{ host, port, location string }{
+		{"example.com:80", "443", "https://example.com/p"},
+		{"example.com", "", "https://example.com/p"},
+		{"[::1]:80", "8443", "https://[::1]:8443/p"},
+		{"[::1]:80", "443", "https://[::1]/p"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+c.host+"/p", nil)
+		rec := httptest.NewRecorder()
+		RedirectHTTPSHandler(c.port).ServeHTTP(rec, req)
+		if got := rec.Header().Get("Location"); got != c.location {
+			t.Fatalf("host %s port %s: expect %q, got %q", c.host, c.port, c.location, got)
+		}
+	}
+}
+
+func Test_HttpServerStartH2C(t *testing.T) {
+	s := &BaseHttpServer{}
+	s.HandleFunc("/proto", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	if err := s.StartH2C("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	url := "http://" + s.ListenAddr().String() + "/proto"
+
+	h2 := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	for _, c := range []struct {
+		client *http.Client
+		proto  string
+	}{{h2, "HTTP/2.0"}, {http.DefaultClient, "HTTP/1.1"}} {
+		resp, err := c.client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != c.proto {
+			t.Fatalf("expect %s, got %q", c.proto, body)
+		}
+	}
+}
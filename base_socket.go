@@ -3,14 +3,15 @@ package gobase
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"net/http"
-	"strings"
 	"sync"
 
 	//"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 	"time"
 
@@ -98,6 +99,8 @@ type BaseTCPStream struct {
 	writtingLoopCloseChan chan struct{}
 	closed                AtomicInt32 //这里使用原子操作，因为在 write data的时候对方关闭连接，会导致read 和 write都会抛异常出来
 	wg                    *sync.WaitGroup
+	closeState            streamCloseState //第一个导致连接不可用的错误，作为关闭原因
+	Logger                Logger
 	IBaseTCPStreamHandle
 }
 
@@ -107,28 +110,49 @@ type BaseTCPSession struct {
 
 type BaseTCPClient struct {
 	BaseTCPStream
-	RemoteAddress string
+	RemoteAddress   string
+	Resolver        Resolver      //为 nil 时使用 net.DefaultResolver
+	DialStrategy    DialStrategy  //多地址时的连接策略
+	FallbackDelay   time.Duration //happy eyeballs 下启动下一个连接尝试的间隔
+	Dialer          ContextDialer //为 nil 时直连，可以设置为 SOCKS5Dialer、HTTPConnectDialer 等代理
+	CircuitBreaker  *CircuitBreaker
+	endpoints       []string
+	connectTimeOut  time.Duration
+	connectDeadLine time.Duration
+	selected        AtomicString
 }
 
 func (c *BaseTCPStream) SetDeadLine(deadLine time.Duration) {
 	c.deadLine = deadLine
 }
 
+func (c *BaseTCPStream) log() Logger {
+	return loggerOrNop(c.Logger)
+}
+
 func (c *BaseTCPStream) Close() {
 	if c.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		reason := c.closeState.reason()
+		c.log().Log(LOG_LEVEL_INFO, "tcp stream closed", "remote", c.Conn.RemoteAddr(), "reason", reason)
 		c.Conn.Close()
 		close(c.writtingLoopCloseChan)
+		notifyClose(c.IBaseTCPStreamHandle, reason)
+	}
+}
 
-		if c.IBaseTCPStreamHandle != nil {
-			c.IBaseTCPStreamHandle.OnClose()
-		}
+//以 err 作为关闭原因关闭连接，例如 NewProtocolError(err)
+func (c *BaseTCPStream) CloseWithError(err error) {
+	if c.closed.Get() == SOCKET_OPEN {
+		c.closeState.record("close", err)
 	}
+	c.Close()
 }
 
 func (c *BaseTCPStream) Write(data []byte) error {
 	if c.closed.Get() == SOCKET_OPEN {
 		if len(c.writeChan) == c.writeChanSize {
-			err := errors.New("write chan overflow, discard data")
+			err := NewStreamError("write", ErrWriteOverflow)
+			c.log().Log(LOG_LEVEL_WARN, "tcp stream write chan overflow, discard data", "remote", c.Conn.RemoteAddr(), "size", len(data))
 			return err
 		} else {
 			c.writeChan <- data
@@ -155,6 +179,7 @@ func (c *BaseTCPStream) readLoop() {
 	for {
 		n, err := c.Conn.Read(p)
 		if err != nil {
+			err = c.onError("read", err)
 			if c.IBaseTCPStreamHandle != nil {
 				c.IBaseTCPStreamHandle.OnException(err)
 			}
@@ -193,6 +218,7 @@ func (c *BaseTCPStream) activeFlush() {
 func (c *BaseTCPStream) write(data []byte) {
 	if c.writer.Buffered() == 0 {
 		if n, err := c.Conn.Write(data); err != nil {
+			err = c.onError("write", err)
 			if c.IBaseTCPStreamHandle != nil {
 				c.IBaseTCPStreamHandle.OnException(err)
 			}
@@ -210,6 +236,7 @@ func (c *BaseTCPStream) write(data []byte) {
 
 func (c *BaseTCPStream) writeBuffer(data []byte) {
 	if _, err := c.writer.Write(data); err != nil {
+		err = c.onError("write", err)
 		if c.IBaseTCPStreamHandle != nil {
 			c.IBaseTCPStreamHandle.OnException(err)
 		}
@@ -223,6 +250,7 @@ func (c *BaseTCPStream) flush() {
 		if err == io.ErrShortWrite {
 			c.activeFlush()
 		} else {
+			err = c.onError("flush", err)
 			if c.IBaseTCPStreamHandle != nil {
 				c.IBaseTCPStreamHandle.OnException(err)
 			}
@@ -247,6 +275,7 @@ func (c *BaseTCPStream) start(deadLine time.Duration) {
 	c.writtingLoopCloseChan = make(chan struct{})
 	c.Conn.SetDeadline(time.Now().Add(c.deadLine * time.Second))
 	//c.Conn.(*net.TCPConn).SetNoDelay(false)
+	c.closeState.reset()
 
 	c.closed.Set(SOCKET_OPEN)
 
@@ -258,6 +287,16 @@ func (c *BaseTCPStream) start(deadLine time.Duration) {
 
 }
 
+//本端 Close 之后读写返回的错误不作为关闭原因
+func (c *BaseTCPStream) onError(op string, err error) error {
+	if c.closed.Get() == SOCKET_OPEN {
+		se := c.closeState.record(op, err)
+		c.log().Log(LOG_LEVEL_DEBUG, "tcp stream "+op+" failed", "remote", c.Conn.RemoteAddr(), "kind", se.Kind, "err", err)
+		return se
+	}
+	return NewStreamError(op, err)
+}
+
 /// TCP Session
 func (c *BaseTCPSession) Start() {
 	c.StartByDeadLine(DEFAULT_DEADLINE)
@@ -292,31 +331,28 @@ func (c *BaseTCPClient) ConnectByAddrTimeOut(addr string, timeOut time.Duration,
 	c.closed.Set(SOCKET_CLOSED)
 	c.RemoteAddress = addr
 	//阻塞
-	conn, err := net.DialTimeout("tcp", addr, timeOut*time.Second)
+	ctx, cancel := connectContext(timeOut)
+	conn, err := c.dialContext(ctx, addr)
+	cancel()
 	if err != nil {
-		//log.Error("connect failed, err: ", err)
+		c.log().Log(LOG_LEVEL_WARN, "tcp client connect failed", "addr", addr, "err", err)
 		c.IBaseTCPStreamHandle.(IBaseTCPClientHandle).OnConnect(false)
 		return err
 	}
 
-	//wait until readLoop and writeLoop exit as c.Conn may used by them
-	if c.wg != nil {
-		c.wg.Wait()
-	}
-	c.Conn = conn
-
-	c.start(deadLine)
-
-	if _, ok := c.IBaseTCPStreamHandle.(IBaseTCPClientHandle); ok {
-		c.IBaseTCPStreamHandle.(IBaseTCPClientHandle).OnConnect(true)
-	}
+	c.startConn(conn, addr, deadLine)
 	return nil
 }
 
-//addr: "127.0.0.1:80"
+//addr: "127.0.0.1:80"，c.Dialer 不为 nil 时无法绑定本端地址，返回 ErrLocalAddrWithDialer
 func (c *BaseTCPClient) ConnectByAddrTimeOutWithLocal(localIP string, localPort int, addr string, timeout time.Duration, deadLine time.Duration) error {
 	c.closed.Set(SOCKET_CLOSED)
 	c.RemoteAddress = addr
+	if c.Dialer != nil {
+		c.log().Log(LOG_LEVEL_WARN, "tcp client connect failed", "addr", addr, "err", ErrLocalAddrWithDialer)
+		c.IBaseTCPStreamHandle.(IBaseTCPClientHandle).OnConnect(false)
+		return ErrLocalAddrWithDialer
+	}
 
 	localAddr := &net.TCPAddr{Port: localPort}
 	if len(localIP) != 0 {
@@ -324,17 +360,22 @@ func (c *BaseTCPClient) ConnectByAddrTimeOutWithLocal(localIP string, localPort
 	}
 
 	//阻塞
-	d := net.Dialer{
+	d := &net.Dialer{
 		LocalAddr: localAddr,
 		Timeout:   timeout * time.Second,
 	}
-	conn, err := d.Dial("tcp", addr)
+	conn, err := c.breakerDialer(d).DialContext(context.Background(), "tcp", addr)
 	if err != nil {
-		//log.Error("connect failed, err: ", err)
+		c.log().Log(LOG_LEVEL_WARN, "tcp client connect failed", "addr", addr, "local", localAddr, "err", err)
 		c.IBaseTCPStreamHandle.(IBaseTCPClientHandle).OnConnect(false)
 		return err
 	}
 
+	c.startConn(conn, addr, deadLine)
+	return nil
+}
+
+func (c *BaseTCPClient) startConn(conn net.Conn, addr string, deadLine time.Duration) {
 	//wait until readLoop and writeLoop exit as c.Conn may used by them
 	if c.wg != nil {
 		c.wg.Wait()
@@ -342,11 +383,11 @@ func (c *BaseTCPClient) ConnectByAddrTimeOutWithLocal(localIP string, localPort
 	c.Conn = conn
 
 	c.start(deadLine)
+	c.log().Log(LOG_LEVEL_INFO, "tcp client connected", "addr", addr, "local", conn.LocalAddr())
 
 	if _, ok := c.IBaseTCPStreamHandle.(IBaseTCPClientHandle); ok {
 		c.IBaseTCPStreamHandle.(IBaseTCPClientHandle).OnConnect(true)
 	}
-	return nil
 }
 
 ////   TCP SERVER
@@ -378,17 +419,24 @@ func (h *BaseTCPServerHandle) OnException(err error) {
 type BaseTCPServer struct {
 	net.Listener
 	closed AtomicInt32
+	loops  []*eventLoop
+	Logger Logger
 	IBaseTCPServerHandle
 }
 
+func (s *BaseTCPServer) log() Logger {
+	return loggerOrNop(s.Logger)
+}
+
 func (s *BaseTCPServer) StartByAddr(addr string) (err error) {
 	s.Listener, err = net.Listen("tcp", addr)
 	if err != nil {
-		//log.Error("server bind %s failed, err: %s", addr, err.Error())
+		s.log().Log(LOG_LEVEL_ERROR, "tcp server bind failed", "addr", addr, "err", err)
 		goto end
 	} else {
-		//log.Info("server bind %s successed.", addr)
+		s.log().Log(LOG_LEVEL_INFO, "tcp server bind successed", "addr", s.Listener.Addr())
 	}
+	s.closed.Set(SOCKET_OPEN)
 
 	if s.IBaseTCPServerHandle != nil {
 		s.IBaseTCPServerHandle.OnStart()
@@ -403,15 +451,31 @@ func (s *BaseTCPServer) Start(ip string, port int32) error {
 	return s.StartByAddr(addr)
 }
 
+//使用外部创建的 listener，例如测试中的内存 listener
+func (s *BaseTCPServer) StartByListener(ln net.Listener) error {
+	s.Listener = ln
+	s.log().Log(LOG_LEVEL_INFO, "tcp server started", "addr", ln.Addr())
+	s.closed.Set(SOCKET_OPEN)
+
+	if s.IBaseTCPServerHandle != nil {
+		s.IBaseTCPServerHandle.OnStart()
+	}
+
+	go s.acceptLoop()
+	return nil
+}
+
 func (s *BaseTCPServer) acceptLoop() {
 	for {
 		conn, err := s.Listener.Accept()
 		if err != nil {
+			s.logAcceptError(err)
 			if s.IBaseTCPServerHandle != nil {
 				s.IBaseTCPServerHandle.OnException(err)
 			}
 			break
 		}
+		s.log().Log(LOG_LEVEL_DEBUG, "tcp server accepted", "remote", conn.RemoteAddr())
 		if s.IBaseTCPServerHandle != nil {
 			s.IBaseTCPServerHandle.OnAccept(conn)
 		}
@@ -419,9 +483,19 @@ func (s *BaseTCPServer) acceptLoop() {
 	return
 }
 
+func (s *BaseTCPServer) logAcceptError(err error) {
+	if s.closed.Get() == SOCKET_CLOSED {
+		s.log().Log(LOG_LEVEL_DEBUG, "tcp server accept loop stopped", "addr", s.Listener.Addr())
+	} else {
+		s.log().Log(LOG_LEVEL_ERROR, "tcp server accept failed", "addr", s.Listener.Addr(), "err", err)
+	}
+}
+
 func (s *BaseTCPServer) Close() {
 	if s.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		s.log().Log(LOG_LEVEL_INFO, "tcp server closed", "addr", s.Listener.Addr())
 		s.Listener.Close()
+		s.stopEventLoops()
 		//s.closed = true
 		if s.IBaseTCPServerHandle != nil {
 			s.IBaseTCPServerHandle.OnClose()
@@ -445,39 +519,65 @@ type UDPMsg struct {
 type BaseUDPStream struct {
 	net.Conn
 	IBaseUDPStreamHandle
+	Logger                Logger
+	ReadBufferSize        int           //单个数据报的最大长度，超过时丢弃并 OnException，0 时为 SOCKET_READ_BUFFER_SIZE，最大 UDP_MAX_DATAGRAM_SIZE
+	BatchSize             int           //>1 时一次系统调用收发多个数据报（Linux 上为 recvmmsg/sendmmsg）
+	Readers               int           //读 goroutine 数，>1 时在 Linux 上用 SO_REUSEPORT 打开多个 socket 分摊到多个核
+	ReuseReadBuffer       bool          //为 true 时 OnRead 的 data 只在回调期间有效，省去一次拷贝
+	Fragmentation         bool          //为 true 时发送的消息按 FragmentSize 分片，收到的分片重组后再 OnRead，两端都需要启用
+	FragmentSize          int           //每个分片数据报（含分片头）的最大长度，0 时为 DEFAULT_UDP_FRAGMENT_SIZE，接收端的 ReadBufferSize 不能比它小
+	ReassemblyTimeOut     time.Duration //没有收齐的消息的保留时间，0 时为 DEFAULT_UDP_REASSEMBLY_TIMEOUT，unit: second
+	MaxReassemblyBytes    int           //所有正在重组的消息占用的最大字节数（含每个消息的分片表），0 时为 DEFAULT_UDP_REASSEMBLY_MAX_BYTES
+	MaxReassemblyMessages int           //同时重组的最大消息数，0 时为 DEFAULT_UDP_REASSEMBLY_MAX_MESSAGES
+	Sealer                *UDPSealer    //不为 nil 时每个数据报都加密认证，认证失败的数据报计数后丢弃
 	closed                AtomicInt32
+	readerConns           []*net.UDPConn
+	dstHandle             IBaseUDPDstHandle
+	demux                 func(data []byte, addr *net.UDPAddr) //不为 nil 时代替 handle 的 OnRead
+	onUnreachable         func(err error)                      //不为 nil 时 ICMP 不可达不中断读循环
 	writeChan             chan *UDPMsg
 	writtingLoopCloseChan chan bool
 	writeEmptyWait        *sync.WaitGroup
+	closeState            streamCloseState
+	limiter               *UDPRateLimiter
+	fragmentID            AtomicUint32
+	reassembler           udpReassembler
 }
 
+//addr: "0.0.0.0:8000"、"[::1]:8000"、"[fe80::1%eth0]:8000"、":8000"，端口为 0 时由系统分配，
+//实际地址通过 LocalUDPAddr 获取
 func (s *BaseUDPStream) StartByAddr(addr string) error {
-	if addrList := strings.Split(addr, ":"); len(addrList) != 2 {
-		return errors.New("err addr format")
-	} else {
-		strIP := addrList[0]
-		strPort := addrList[1]
-		if port, err := strconv.Atoi(strPort); err != nil {
-			return errors.New("err addr format, err: " + err.Error())
-		} else {
-			return s.Start(strIP, int32(port))
-		}
+	network, udpAddr, err := resolveUDPListenAddr(addr)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "udp bind failed", "addr", addr, "err", err)
+		return err
 	}
+	return s.listen(network, udpAddr)
+}
 
+//ip 为空或 "::" 时监听双栈
+func (s *BaseUDPStream) Start(ip string, port int32) error {
+	return s.StartByAddr(net.JoinHostPort(ip, strconv.FormatInt(int64(port), 10)))
 }
 
-func (s *BaseUDPStream) Start(ip string, port int32) (err error) {
-	udpAddr := &net.UDPAddr{
-		IP:   net.ParseIP(ip),
-		Port: int(port),
-	}
-	s.Conn, err = net.ListenUDP("udp4", udpAddr)
+func (s *BaseUDPStream) listen(network string, udpAddr *net.UDPAddr) (err error) {
+	s.readerConns, err = listenUDPReaders(network, udpAddr, s.Readers)
 	if err != nil {
-		//log.Error("server bind %s:%d failed, err: %v", ip, port, err)
+		s.log().Log(LOG_LEVEL_ERROR, "udp bind failed", "network", network, "addr", udpAddr, "err", err)
 		goto end
 	} else {
-		//log.Infof("server bind %s:%d successed.", ip, port)
+		s.Conn = s.readerConns[0]
+		s.log().Log(LOG_LEVEL_INFO, "udp bind successed", "network", network, "addr", s.Conn.LocalAddr(), "sockets", len(s.readerConns))
 	}
+	s.run()
+end:
+	return
+}
+
+func (s *BaseUDPStream) run() {
+	s.closeState.reset()
+	s.closed.Set(SOCKET_OPEN)
+	s.enableDst()
 	if s.IBaseUDPStreamHandle != nil {
 		s.IBaseUDPStreamHandle.OnStart()
 	}
@@ -485,35 +585,39 @@ func (s *BaseUDPStream) Start(ip string, port int32) (err error) {
 	s.writeChan = make(chan *UDPMsg, 1000)
 	s.writtingLoopCloseChan = make(chan bool, 1)
 	s.writeEmptyWait = &sync.WaitGroup{}
-	go s.readLoop()
+	s.startReaders()
 	go s.writeLoop()
-end:
-	return
 }
 
-func (s *BaseUDPStream) readLoop() {
+func (s *BaseUDPStream) readLoop(conn *net.UDPConn, primary bool) {
+	buf := getUDPReadBuffer(s.readBufferSize())
+	defer putUDPReadBuffer(buf)
+	oob, parseDst := s.dstControl(conn)
 	for {
-		p := make([]byte, SOCKET_READ_BUFFER_SIZE)
-		n, addr, err := s.Conn.(*net.UDPConn).ReadFromUDP(p)
+		n, oobn, flags, addr, err := conn.ReadMsgUDP(buf, oob)
 		if err != nil {
-			if s.IBaseUDPStreamHandle != nil {
-				s.IBaseUDPStreamHandle.OnException(err)
+			if s.onReadError(err, primary) {
+				continue
 			}
 			break
 		}
-		if s.IBaseUDPStreamHandle != nil {
-			s.IBaseUDPStreamHandle.OnRead(p[:n], addr)
-		}
+		s.onDatagram(buf[:n], flags, addr, parseDst(oob[:oobn]))
 	}
 }
 
 //udp的底层write有锁
 func (s *BaseUDPStream) writeLoop() {
+	if s.BatchSize > 1 {
+		s.batchWriteLoop()
+		return
+	}
 exit1:
 	for {
 		select {
 		case udpMsg := <-s.writeChan:
-			s.Conn.(*net.UDPConn).WriteTo(udpMsg.data, udpMsg.destAddr)
+			if _, err := s.writeMsg(udpMsg); err != nil {
+				s.log().Log(LOG_LEVEL_WARN, "udp write failed", "dest", udpMsg.destAddr, "size", len(udpMsg.data), "err", err)
+			}
 			s.writeEmptyWait.Done()
 		case <-s.writtingLoopCloseChan:
 			//log.Trace("session writting chan stoped")
@@ -528,12 +632,100 @@ func (s *BaseUDPStream) WriteToUDP(data []byte, addr *net.UDPAddr) {
 }
 
 func (s *BaseUDPStream) WriteTo(data []byte, addr net.Addr) {
-	s.writeEmptyWait.Add(1)
-	udpMsg := &UDPMsg{
-		data:     data,
-		destAddr: addr,
+	datagrams := [][]byte{data}
+	if s.Fragmentation {
+		if datagrams = s.fragment(data); datagrams == nil {
+			s.log().Log(LOG_LEVEL_WARN, "udp message too large to fragment, discard", "dest", addr, "size", len(data))
+			return
+		}
+	}
+	if s.Sealer != nil {
+		for i, datagram := range datagrams {
+			sealed, err := s.Sealer.Seal(datagram)
+			if err != nil {
+				s.log().Log(LOG_LEVEL_WARN, "udp seal failed, discard", "dest", addr, "size", len(data), "err", err)
+				return
+			}
+			datagrams[i] = sealed
+		}
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && s.limiter != nil {
+		size := 0
+		for _, datagram := range datagrams {
+			size += len(datagram)
+		}
+		if !s.limiter.allowResponse(udpAddr, size) {
+			s.log().Log(LOG_LEVEL_DEBUG, "udp response to unverified source exceeds amplification limit, discard", "dest", addr, "size", size)
+			return
+		}
+	}
+	s.writeEmptyWait.Add(len(datagrams))
+	for _, datagram := range datagrams {
+		s.writeChan <- &UDPMsg{data: datagram, destAddr: addr}
 	}
-	s.writeChan <- udpMsg
+}
+
+//addr 为 "host:port"，解析失败时返回错误
+func (s *BaseUDPStream) WriteToAddr(data []byte, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	s.WriteTo(data, udpAddr)
+	return nil
+}
+
+//实际绑定的地址，包含系统分配的端口
+func (s *BaseUDPStream) LocalUDPAddr() *net.UDPAddr {
+	if s.Conn == nil {
+		return nil
+	}
+	addr, _ := s.Conn.LocalAddr().(*net.UDPAddr)
+	return addr
+}
+
+func (s *BaseUDPStream) Port() int {
+	if addr := s.LocalUDPAddr(); addr != nil {
+		return addr.Port
+	}
+	return 0
+}
+
+//IPv4 地址使用 udp4，IPv6 地址使用 udp6，空地址和 "::" 使用双栈的 udp
+func resolveUDPListenAddr(addr string) (string, *net.UDPAddr, error) {
+	host, strPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", nil, errors.New("err addr format, err: " + err.Error())
+	}
+	port, err := strconv.ParseUint(strPort, 10, 16)
+	if err != nil {
+		return "", nil, errors.New("err addr format, err: " + err.Error())
+	}
+	if host == "" {
+		return "udp", &net.UDPAddr{Port: int(port)}, nil
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return "", nil, err
+		}
+		if udpAddr.IP.To4() != nil {
+			return "udp4", udpAddr, nil
+		}
+		return "udp6", udpAddr, nil
+	}
+	if ip.Is4In6() {
+		ip = ip.Unmap()
+	}
+	udpAddr := net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port)))
+	switch {
+	case ip.Is4():
+		return "udp4", udpAddr, nil
+	case ip.IsUnspecified():
+		return "udp", udpAddr, nil
+	}
+	return "udp6", udpAddr, nil
 }
 
 func (s *BaseUDPStream) Flush() {
@@ -542,15 +734,28 @@ func (s *BaseUDPStream) Flush() {
 	}
 }
 
+func (s *BaseUDPStream) log() Logger {
+	return loggerOrNop(s.Logger)
+}
+
 func (s *BaseUDPStream) Close() {
 	if s.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
-		s.Conn.Close()
+		reason := s.closeState.reason()
+		s.log().Log(LOG_LEVEL_INFO, "udp closed", "local", s.Conn.LocalAddr(), "reason", reason)
+		for _, conn := range s.readerConns {
+			conn.Close()
+		}
 		//s.closed = true
 		s.writtingLoopCloseChan <- true
-		if s.IBaseUDPStreamHandle != nil {
-			s.IBaseUDPStreamHandle.OnClose()
-		}
+		notifyClose(s.IBaseUDPStreamHandle, reason)
+	}
+}
+
+func (s *BaseUDPStream) CloseWithError(err error) {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.closeState.record("close", err)
 	}
+	s.Close()
 }
 
 ///  UDP Client
@@ -575,6 +780,9 @@ func (h *BaseUDPClientHandle) OnClose() {
 
 type BaseUDPClient struct {
 	BaseUDPStream
+	remote    *net.UDPAddr //Connect 之后不为 nil
+	waitersMu sync.Mutex
+	waiters   []*udpWaiter
 }
 
 ///   UDP Server
@@ -599,6 +807,13 @@ func (h *BaseUDPServerHandle) OnClose() {
 
 type BaseUDPServer struct {
 	BaseUDPStream
+	NewSession         UDPSessionHandleFactory //不为 nil 时按远端地址创建 BaseUDPSession，不再回调 OnRead(data, addr)
+	SessionIdleTimeOut time.Duration           //会话没有收到数据的超时，unit: second，0 时为 DEFAULT_DEADLINE
+	MaxSessions        int                     //会话数上限，达到后丢弃新远端的数据报，0 表示不限制
+	RateLimiter        *UDPRateLimiter         //不为 nil 时按来源 IP 限速，并限制对未验证来源的响应大小
+	sessionsMu         sync.Mutex
+	sessions           map[netip.AddrPort]*BaseUDPSession
+	sessionsQuit       chan struct{}
 }
 
 /// Unix Socket
@@ -637,13 +852,17 @@ type BaseUnixStream struct {
 	net.Conn
 	deadLine      time.Duration //unit: second
 	writer        *bufio.Writer
-	writeChan     chan []byte
+	writeChan     chan unixWrite
 	writeChanSize int
 	flushChan     chan bool
 
 	writtingLoopCloseChan chan struct{}
 	closed                AtomicInt32
 	wg                    *sync.WaitGroup
+	closeState            streamCloseState
+	packet                bool //unixpacket 连接，每次 Write 是一个消息，OnRead 也是一个完整的消息
+	Logger                Logger
+	ReadBufferSize        int //unixpacket 单个消息的最大长度，超过时丢弃并 OnException，0 时为 UNIX_PACKET_MAX_SIZE
 	IBaseUnixStreamHandle
 }
 
@@ -653,30 +872,45 @@ type BaseUnixSession struct {
 
 type BaseUnixClient struct {
 	BaseUnixStream
-	RemoteAddress string
+	RemoteAddress  string
+	Network        string //"unix"（默认）或 "unixpacket"
+	CircuitBreaker *CircuitBreaker
 }
 
 func (c *BaseUnixStream) SetDeadLine(deadLine time.Duration) {
 	c.deadLine = deadLine
 }
 
+func (c *BaseUnixStream) log() Logger {
+	return loggerOrNop(c.Logger)
+}
+
 func (c *BaseUnixStream) Close() {
 	if c.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		reason := c.closeState.reason()
+		c.log().Log(LOG_LEVEL_INFO, "unix stream closed", "local", c.Conn.LocalAddr(), "reason", reason)
 		c.Conn.Close()
 		close(c.writtingLoopCloseChan)
-		if c.IBaseUnixStreamHandle != nil {
-			c.IBaseUnixStreamHandle.OnClose()
-		}
+		notifyClose(c.IBaseUnixStreamHandle, reason)
 	}
 }
 
+//以 err 作为关闭原因关闭连接，例如 NewProtocolError(err)
+func (c *BaseUnixStream) CloseWithError(err error) {
+	if c.closed.Get() == SOCKET_OPEN {
+		c.closeState.record("close", err)
+	}
+	c.Close()
+}
+
 func (c *BaseUnixStream) Write(data []byte) error {
 	if c.closed.Get() == SOCKET_OPEN {
 		if len(c.writeChan) == c.writeChanSize {
-			err := errors.New("write chan overflow, discard data")
+			err := NewStreamError("write", ErrWriteOverflow)
+			c.log().Log(LOG_LEVEL_WARN, "unix stream write chan overflow, discard data", "local", c.Conn.LocalAddr(), "size", len(data))
 			return err
 		} else {
-			c.writeChan <- data
+			c.writeChan <- unixWrite{data: data}
 			return nil
 		}
 	}
@@ -698,10 +932,15 @@ func (c *BaseUnixStream) Flush() {
 
 func (c *BaseUnixStream) readLoop() {
 	defer c.wg.Done()
+	if c.packet || c.fdsHandle() != nil {
+		c.packetReadLoop()
+		return
+	}
 	p := make([]byte, SOCKET_READ_BUFFER_SIZE)
 	for {
 		n, err := c.Conn.Read(p)
 		if err != nil {
+			err = c.onError("read", err)
 			if c.IBaseUnixStreamHandle != nil {
 				c.IBaseUnixStreamHandle.OnException(err)
 			}
@@ -721,8 +960,12 @@ func (c *BaseUnixStream) writeLoop() {
 exit1:
 	for {
 		select {
-		case data := <-c.writeChan:
-			c.write(data)
+		case w := <-c.writeChan:
+			if w.rights != nil {
+				c.writeWithRights(w)
+			} else {
+				c.write(w.data)
+			}
 		case <-c.flushChan:
 			c.flush()
 		case <-c.writtingLoopCloseChan:
@@ -730,12 +973,18 @@ exit1:
 			break exit1
 		}
 	}
+	c.releasePendingRights()
 	//log.Trace("writting loop stopped..")
 }
 
 func (c *BaseUnixStream) write(data []byte) {
+	if c.packet {
+		c.writePacket(data)
+		return
+	}
 	if c.writer.Buffered() == 0 {
 		if n, err := c.Conn.Write(data); err != nil {
+			err = c.onError("write", err)
 			if c.IBaseUnixStreamHandle != nil {
 				c.IBaseUnixStreamHandle.OnException(err)
 			}
@@ -759,6 +1008,7 @@ func (c *BaseUnixStream) activeFlush() {
 
 func (c *BaseUnixStream) writeBuffer(data []byte) {
 	if _, err := c.writer.Write(data); err != nil {
+		err = c.onError("write", err)
 		if c.IBaseUnixStreamHandle != nil {
 			c.IBaseUnixStreamHandle.OnException(err)
 		}
@@ -772,6 +1022,7 @@ func (c *BaseUnixStream) flush() {
 		if err == io.ErrShortWrite {
 			c.activeFlush()
 		} else {
+			err = c.onError("flush", err)
 			if c.IBaseUnixStreamHandle != nil {
 				c.IBaseUnixStreamHandle.OnException(err)
 			}
@@ -790,12 +1041,14 @@ func (c *BaseUnixStream) start(deadLine time.Duration) {
 	c.deadLine = deadLine
 	c.writer = bufio.NewWriterSize(c.Conn, 32*1024)
 	c.writeChanSize = 3000
-	c.writeChan = make(chan []byte, c.writeChanSize)
+	c.writeChan = make(chan unixWrite, c.writeChanSize)
 	c.flushChan = make(chan bool, 10)
 	c.writtingLoopCloseChan = make(chan struct{})
 	//c.writeEmptyWait = &sync.WaitGroup{}
 	c.Conn.SetDeadline(time.Now().Add(c.deadLine * time.Second))
 	//c.Conn.(*net.TCPConn).SetNoDelay(false)
+	c.closeState.reset()
+	c.packet = c.Conn.LocalAddr().Network() == "unixpacket"
 
 	c.closed.Set(SOCKET_OPEN)
 
@@ -806,6 +1059,16 @@ func (c *BaseUnixStream) start(deadLine time.Duration) {
 	go c.writeLoop()
 }
 
+//本端 Close 之后读写返回的错误不作为关闭原因
+func (c *BaseUnixStream) onError(op string, err error) error {
+	if c.closed.Get() == SOCKET_OPEN {
+		se := c.closeState.record(op, err)
+		c.log().Log(LOG_LEVEL_DEBUG, "unix stream "+op+" failed", "local", c.Conn.LocalAddr(), "kind", se.Kind, "err", err)
+		return se
+	}
+	return NewStreamError(op, err)
+}
+
 /// UnixSock Session
 func (c *BaseUnixSession) Start() {
 	c.StartByDeadLine(DEFAULT_DEADLINE)
@@ -828,16 +1091,30 @@ func (c *BaseUnixClient) ConnectByAddr(addr string) error {
 func (c *BaseUnixClient) ConnectByAddrWithDeadLine(addr string, deadLine time.Duration) error {
 	c.closed.Set(SOCKET_CLOSED)
 	c.RemoteAddress = addr
-	unixAddr, err := net.ResolveUnixAddr("unix", addr)
+	network := c.Network
+	if network == "" {
+		network = "unix"
+	}
+	unixAddr, err := net.ResolveUnixAddr(network, addr)
 	if err != nil {
+		c.log().Log(LOG_LEVEL_WARN, "unix client resolve failed", "addr", addr, "err", err)
 		c.IBaseUnixStreamHandle.(IBaseUnixClientHandle).OnException(err)
 		return err
 	}
 
 	//阻塞
-	conn, err := net.DialUnix("unix", nil, unixAddr)
+	if c.CircuitBreaker != nil {
+		var done func(error)
+		if done, err = c.CircuitBreaker.Allow(addr); err != nil {
+			c.log().Log(LOG_LEVEL_WARN, "unix client connect failed", "addr", addr, "err", err)
+			c.IBaseUnixStreamHandle.(IBaseUnixClientHandle).OnConnect(false)
+			return err
+		}
+		defer func() { done(err) }()
+	}
+	conn, err := net.DialUnix(network, nil, unixAddr)
 	if err != nil {
-		//log.Error("connect failed, err: ", err)
+		c.log().Log(LOG_LEVEL_WARN, "unix client connect failed", "addr", addr, "err", err)
 		c.IBaseUnixStreamHandle.(IBaseUnixClientHandle).OnConnect(false)
 		return err
 	}
@@ -849,6 +1126,7 @@ func (c *BaseUnixClient) ConnectByAddrWithDeadLine(addr string, deadLine time.Du
 	c.Conn = conn
 
 	c.start(deadLine)
+	c.log().Log(LOG_LEVEL_INFO, "unix client connected", "addr", addr)
 
 	if _, ok := c.IBaseUnixStreamHandle.(IBaseUnixClientHandle); ok {
 		c.IBaseUnixStreamHandle.(IBaseUnixClientHandle).OnConnect(true)
@@ -884,22 +1162,50 @@ func (h *BaseUnixServerHandle) OnException(err error) {
 
 type BaseUnixServer struct {
 	net.Listener
-	closed bool
+	closed  bool
+	Logger  Logger
+	Network string //"unix"（默认）或 "unixpacket"，unixpacket 时 OnAccept 的连接用 BaseUnixSession 按消息收发
+	UnixSocketOptions
+	//不为 nil 时在 OnAccept 之前按对端凭证鉴权，返回错误时关闭连接并以 ErrUnixPeerRejected 回调 OnException；
+	//对端凭证目前只支持 Linux，其他平台上设置时 StartByAddr 返回 errors.ErrUnsupported
+	Authorize func(conn *net.UnixConn, cred *UnixCredentials) error
 	IBaseUnixServerHandle
 }
 
+func (s *BaseUnixServer) log() Logger {
+	return loggerOrNop(s.Logger)
+}
+
 func (s *BaseUnixServer) StartByAddr(addr string) (err error) {
 	var unixAddr *net.UnixAddr
-	unixAddr, err = net.ResolveUnixAddr("unix", addr)
+	network := s.Network
+	if network == "" {
+		network = "unix"
+	}
+	if err = s.checkAuthorize(); err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unix server authorize not supported", "addr", addr, "err", err)
+		goto end
+	}
+	unixAddr, err = net.ResolveUnixAddr(network, addr)
 	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unix server resolve failed", "addr", addr, "err", err)
 		goto end
 	}
-	s.Listener, err = net.ListenUnix("unix", unixAddr)
+	if err = s.prepare(network, addr); err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unix server bind failed", "addr", addr, "err", err)
+		goto end
+	}
+	s.Listener, err = net.ListenUnix(network, unixAddr)
 	if err != nil {
-		//log.Error("server bind %s failed, err: %s", addr, err.Error())
+		s.log().Log(LOG_LEVEL_ERROR, "unix server bind failed", "addr", addr, "err", err)
+		goto end
+	} else if err = s.apply(addr); err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unix server set socket file options failed", "addr", addr, "err", err)
+		s.Listener.Close()
+		s.cleanup(addr)
 		goto end
 	} else {
-		//log.Info("server bind %s successed.", addr)
+		s.log().Log(LOG_LEVEL_INFO, "unix server bind successed", "addr", addr)
 	}
 	if s.IBaseUnixServerHandle != nil {
 		s.IBaseUnixServerHandle.OnStart()
@@ -914,11 +1220,16 @@ func (s *BaseUnixServer) acceptLoop() {
 	for {
 		conn, err := s.Listener.(*net.UnixListener).AcceptUnix()
 		if err != nil {
+			s.log().Log(LOG_LEVEL_DEBUG, "unix server accept loop stopped", "addr", s.Listener.Addr(), "err", err)
 			if s.IBaseUnixServerHandle != nil {
 				s.IBaseUnixServerHandle.OnException(err)
 			}
 			break
 		}
+		s.log().Log(LOG_LEVEL_DEBUG, "unix server accepted", "addr", s.Listener.Addr())
+		if s.Authorize != nil && !s.authorize(conn) {
+			continue
+		}
 		if s.IBaseUnixServerHandle != nil {
 			s.IBaseUnixServerHandle.OnAccept(conn)
 		}
@@ -928,7 +1239,9 @@ func (s *BaseUnixServer) acceptLoop() {
 
 func (s *BaseUnixServer) Close() {
 	if s.closed != true {
+		s.log().Log(LOG_LEVEL_INFO, "unix server closed", "addr", s.Listener.Addr())
 		s.Listener.Close()
+		s.cleanup(s.Listener.Addr().String())
 		s.closed = true
 		if s.IBaseUnixServerHandle != nil {
 			s.IBaseUnixServerHandle.OnClose()
@@ -940,47 +1253,83 @@ func Vars(r *http.Request) map[string]string {
 	return mux.Vars(r)
 }
 
-type Router struct {
-	mux.Router
-}
-
 type BaseHttpServer struct {
 	http.Server
-	listener net.Listener
+	Options      HttpServerOptions
+	TLS          TLSOptions
+	listener     net.Listener
+	Logger       Logger
+	router       *Router
+	middlewares  []Middleware
+	wrapped      bool
+	h2c          bool
+	certs        *CertReloader
+	tlsQuit      chan struct{}
+	redirect     *http.Server
+	redirectAddr net.Addr
+	serveDone    chan struct{}
+	serveErrs    chan error
+	serveErr     error
+}
+
+func (s *BaseHttpServer) log() Logger {
+	return loggerOrNop(s.Logger)
 }
 
 func (s *BaseHttpServer) checkRouter() {
-	if s.Handler == nil {
+	if s.router != nil {
+		return
+	}
+	switch h := s.Handler.(type) {
+	case nil:
 		//s.Handler = http.NewServeMux()
-		s.Handler = mux.NewRouter()
+		s.router = NewRouter()
+		s.Handler = s.router.Router
+	case *Router:
+		s.router = h
+	case *mux.Router:
+		s.router = &Router{Router: h}
 	}
 }
 
-func (s *BaseHttpServer) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) *mux.Route {
+//middlewares 只作用于这个路由，按顺序从外到内
+func (s *BaseHttpServer) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request), middlewares ...Middleware) *mux.Route {
 	s.checkRouter()
-	return s.Handler.(*mux.Router).HandleFunc(pattern, handler)
+	if len(middlewares) > 0 {
+		return s.router.Handle(pattern, Chain(middlewares...)(http.HandlerFunc(handler)))
+	}
+	return s.router.HandleFunc(pattern, handler)
 }
 
+//Handler 不是 *Router 或 *mux.Router 时返回 nil
 func (s *BaseHttpServer) Router() *Router {
 	s.checkRouter()
-	return s.Handler.(*Router)
+	return s.router
 }
 
 //addr: "ip:port"
 func (s *BaseHttpServer) Start(addr string) error {
 	if listener, err := s.listen(addr); err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "http server bind failed", "addr", addr, "err", err)
 		return err
 	} else {
-		s.listener = listener
-		go s.Serve(listener)
+		s.log().Log(LOG_LEVEL_INFO, "http server bind successed", "addr", listener.Addr())
+		s.serve(listener, s.Serve)
 	}
 	return nil
 }
 
+//立即关闭监听和所有连接，不等待正在处理的请求，需要等待时使用 Shutdown
 func (s *BaseHttpServer) Stop() {
-	if s.listener != nil {
-		s.listener.Close()
+	if s.serveDone == nil {
+		return
 	}
+	s.log().Log(LOG_LEVEL_INFO, "http server stopping", "addr", s.listener.Addr())
+	//ctx 已经结束，重定向服务同样直接关闭
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.stopTLS(ctx)
+	s.Server.Close()
 }
 
 func (s *BaseHttpServer) listen(addr string) (net.Listener, error) {
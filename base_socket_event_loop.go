@@ -0,0 +1,172 @@
+// base_socket_event_loop.go
+package gobase
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//event loop 模式下每个连接未发送数据的上限
+const DEFAULT_EVENT_LOOP_WRITE_BUFFER_LIMIT = 4 * 1024 * 1024
+
+var ErrEventLoopNotSupported = errors.New("event loop mode is not supported on this platform")
+
+//读缓冲只在有数据可读时从池中取出，读完即归还
+var eventLoopReadBufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, SOCKET_READ_BUFFER_SIZE)
+	},
+}
+
+//event loop 模式下的 server handle，由 OnAcceptSession 为每个新连接提供 session handle，
+//返回 nil 则直接关闭该连接
+type IBaseTCPEventLoopServerHandle interface {
+	IBaseTCPServerHandle
+	OnAcceptSession(s *BaseTCPEventLoopSession) IBaseTCPSessionHandle
+}
+
+//event loop 模式下的 TCP session，不占用独立的读写 goroutine，
+//回调约定与 BaseTCPSession 相同，OnRead 中的 data 在回调返回后会被复用
+type BaseTCPEventLoopSession struct {
+	fd         int
+	loop       *eventLoop
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	deadLine   AtomicDuration //unit: second
+	lastActive AtomicInt64
+	mu         sync.Mutex
+	pending    []byte
+	closed     AtomicInt32
+	closeState streamCloseState
+	IBaseTCPSessionHandle
+}
+
+func (s *BaseTCPEventLoopSession) LocalAddr() net.Addr {
+	return s.localAddr
+}
+
+func (s *BaseTCPEventLoopSession) RemoteAddr() net.Addr {
+	return s.remoteAddr
+}
+
+func (s *BaseTCPEventLoopSession) SetDeadLine(deadLine time.Duration) {
+	s.deadLine.Set(deadLine)
+}
+
+func (s *BaseTCPEventLoopSession) Write(data []byte) error {
+	if s.closed.Get() == SOCKET_OPEN {
+		return s.loop.write(s, data)
+	}
+	return nil
+}
+
+func (s *BaseTCPEventLoopSession) WriteString(data string) {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.Write([]byte(data))
+	}
+}
+
+func (s *BaseTCPEventLoopSession) Flush() {
+}
+
+func (s *BaseTCPEventLoopSession) Close() {
+	s.loop.closeSession(s)
+}
+
+func (s *BaseTCPEventLoopSession) CloseWithError(err error) {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.closeState.record("close", err)
+	}
+	s.Close()
+}
+
+func (s *BaseTCPEventLoopSession) log() Logger {
+	return loggerOrNop(s.loop.logger)
+}
+
+func (s *BaseTCPEventLoopSession) touch() {
+	s.lastActive.Set(time.Now().UnixNano())
+}
+
+func (s *BaseTCPEventLoopSession) expired(now time.Time) bool {
+	deadLine := s.deadLine.Get() * time.Second
+	return deadLine > 0 && now.UnixNano()-s.lastActive.Get() > int64(deadLine)
+}
+
+/// TCP Server event loop
+//以 event loop 模式启动，loopNum 为 epoll 循环的 goroutine 数量，<= 0 时为 1
+//s.IBaseTCPServerHandle 必须实现 IBaseTCPEventLoopServerHandle
+func (s *BaseTCPServer) StartEventLoop(ip string, port int32, loopNum int) error {
+	addr := ip + ":" + strconv.FormatInt(int64(port), 10)
+	return s.StartEventLoopByAddr(addr, loopNum)
+}
+
+func (s *BaseTCPServer) StartEventLoopByAddr(addr string, loopNum int) (err error) {
+	handle, ok := s.IBaseTCPServerHandle.(IBaseTCPEventLoopServerHandle)
+	if !ok {
+		return errors.New("server handle must implement IBaseTCPEventLoopServerHandle")
+	}
+	if loopNum <= 0 {
+		loopNum = 1
+	}
+	loops := make([]*eventLoop, 0, loopNum)
+	for i := 0; i < loopNum; i++ {
+		var loop *eventLoop
+		if loop, err = newEventLoop(s.Logger); err != nil {
+			for _, l := range loops {
+				l.stop()
+			}
+			return err
+		}
+		go loop.run()
+		loops = append(loops, loop)
+	}
+
+	s.Listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "tcp server bind failed", "addr", addr, "err", err)
+		for _, l := range loops {
+			l.stop()
+		}
+		return err
+	}
+	s.log().Log(LOG_LEVEL_INFO, "tcp server bind successed", "addr", s.Listener.Addr(), "loops", loopNum)
+	s.loops = loops
+	s.closed.Set(SOCKET_OPEN)
+
+	handle.OnStart()
+
+	go s.eventLoopAcceptLoop(handle)
+	return nil
+}
+
+func (s *BaseTCPServer) eventLoopAcceptLoop(handle IBaseTCPEventLoopServerHandle) {
+	next := 0
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			s.logAcceptError(err)
+			handle.OnException(err)
+			break
+		}
+		s.log().Log(LOG_LEVEL_DEBUG, "tcp server accepted", "remote", conn.RemoteAddr())
+		loop := s.loops[next]
+		next = (next + 1) % len(s.loops)
+		if err := loop.register(conn, handle); err != nil {
+			s.log().Log(LOG_LEVEL_ERROR, "tcp server register conn to event loop failed", "remote", conn.RemoteAddr(), "err", err)
+			handle.OnException(err)
+		}
+	}
+}
+
+func (s *BaseTCPServer) stopEventLoops() {
+	for _, l := range s.loops {
+		l.shutdown()
+	}
+	for _, l := range s.loops {
+		l.stop()
+	}
+}
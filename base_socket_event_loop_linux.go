@@ -0,0 +1,314 @@
+// base_socket_event_loop_linux.go
+//go:build linux
+
+package gobase
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	eventLoopMaxEvents    = 256
+	eventLoopWaitTimeout  = 1000 //ms
+	eventLoopReadEvents   = syscall.EPOLLIN | syscall.EPOLLRDHUP
+	eventLoopWriteEvents  = eventLoopReadEvents | syscall.EPOLLOUT
+	eventLoopErrorEvents  = syscall.EPOLLERR | syscall.EPOLLHUP
+	eventLoopScanInterval = time.Second
+)
+
+type eventLoop struct {
+	epfd     int
+	mu       sync.Mutex
+	sessions map[int]*BaseTCPEventLoopSession
+	closed   AtomicInt32
+	done     chan struct{}
+	logger   Logger
+}
+
+func newEventLoop(logger Logger) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	l := &eventLoop{
+		epfd:     epfd,
+		sessions: make(map[int]*BaseTCPEventLoopSession),
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+	l.closed.Set(SOCKET_OPEN)
+	return l, nil
+}
+
+//把 conn 的 fd 复制出来交给 epoll 管理，原 conn 随即关闭
+func (l *eventLoop) register(conn net.Conn, handle IBaseTCPEventLoopServerHandle) error {
+	defer conn.Close()
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errors.New("event loop: conn does not expose a file descriptor")
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	var dupErr error
+	if err = rawConn.Control(func(s uintptr) {
+		fd, dupErr = syscall.Dup(int(s))
+	}); err != nil {
+		return err
+	}
+	if dupErr != nil {
+		return dupErr
+	}
+	syscall.CloseOnExec(fd)
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	s := &BaseTCPEventLoopSession{
+		fd:         fd,
+		loop:       l,
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+	}
+	s.deadLine.Set(DEFAULT_DEADLINE)
+	s.touch()
+	s.closed.Set(SOCKET_OPEN)
+	s.IBaseTCPSessionHandle = handle.OnAcceptSession(s)
+	if s.IBaseTCPSessionHandle == nil {
+		s.closed.Set(SOCKET_CLOSED)
+		syscall.Close(fd)
+		return nil
+	}
+
+	l.mu.Lock()
+	l.sessions[fd] = s
+	l.mu.Unlock()
+
+	s.IBaseTCPSessionHandle.OnStart()
+
+	//OnStart 中可能已经写入数据并注册了 EPOLLOUT
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Get() == SOCKET_CLOSED {
+		return nil
+	}
+	events := uint32(eventLoopReadEvents)
+	if len(s.pending) > 0 {
+		events = eventLoopWriteEvents
+	}
+	ev := syscall.EpollEvent{Events: events, Fd: int32(fd)}
+	if err = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		if err == syscall.EEXIST {
+			err = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, fd, &ev)
+		}
+		if err != nil {
+			go s.exception("epoll", err)
+		}
+	}
+	return nil
+}
+
+func (l *eventLoop) run() {
+	defer close(l.done)
+	events := make([]syscall.EpollEvent, eventLoopMaxEvents)
+	lastScan := time.Now()
+	for l.closed.Get() == SOCKET_OPEN {
+		n, err := syscall.EpollWait(l.epfd, events, eventLoopWaitTimeout)
+		if err != nil && err != syscall.EINTR {
+			break
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			l.mu.Lock()
+			s := l.sessions[fd]
+			l.mu.Unlock()
+			if s == nil {
+				continue
+			}
+			ev := events[i].Events
+			if ev&(syscall.EPOLLIN|syscall.EPOLLRDHUP|eventLoopErrorEvents) != 0 {
+				l.handleRead(s)
+			}
+			if ev&syscall.EPOLLOUT != 0 {
+				l.handleWrite(s)
+			}
+		}
+		if now := time.Now(); now.Sub(lastScan) >= eventLoopScanInterval {
+			lastScan = now
+			l.closeExpired(now)
+		}
+	}
+
+	l.mu.Lock()
+	sessions := make([]*BaseTCPEventLoopSession, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		sessions = append(sessions, s)
+	}
+	l.mu.Unlock()
+	for _, s := range sessions {
+		l.closeSession(s)
+	}
+	syscall.Close(l.epfd)
+}
+
+//只通知退出，不等待
+func (l *eventLoop) shutdown() {
+	l.closed.Set(SOCKET_CLOSED)
+}
+
+func (l *eventLoop) stop() {
+	l.shutdown()
+	select {
+	case <-l.done:
+	case <-time.After(2 * eventLoopWaitTimeout * time.Millisecond):
+	}
+}
+
+//持有 s.mu 读取，避免 Close 关闭 fd 后 fd 被新连接复用，读到其他连接的数据
+func (l *eventLoop) handleRead(s *BaseTCPEventLoopSession) {
+	p := eventLoopReadBufferPool.Get().([]byte)
+	defer eventLoopReadBufferPool.Put(p)
+	s.mu.Lock()
+	if s.closed.Get() == SOCKET_CLOSED {
+		s.mu.Unlock()
+		return
+	}
+	n, err := syscall.Read(s.fd, p)
+	s.mu.Unlock()
+	if err != nil {
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return
+		}
+		s.exception("read", err)
+		return
+	}
+	if n == 0 {
+		s.exception("read", io.EOF)
+		return
+	}
+	s.touch()
+	s.IBaseTCPSessionHandle.OnRead(p[:n])
+}
+
+func (l *eventLoop) handleWrite(s *BaseTCPEventLoopSession) {
+	s.mu.Lock()
+	if s.closed.Get() == SOCKET_CLOSED {
+		s.mu.Unlock()
+		return
+	}
+	for len(s.pending) > 0 {
+		n, err := syscall.Write(s.fd, s.pending)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				s.mu.Unlock()
+				return
+			}
+			s.mu.Unlock()
+			s.exception("write", err)
+			return
+		}
+		s.pending = s.pending[n:]
+	}
+	s.pending = nil
+	s.touch()
+	ev := syscall.EpollEvent{Events: eventLoopReadEvents, Fd: int32(s.fd)}
+	err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, s.fd, &ev)
+	s.mu.Unlock()
+	if err != nil {
+		s.exception("epoll", err)
+	}
+}
+
+func (l *eventLoop) write(s *BaseTCPEventLoopSession, data []byte) error {
+	s.mu.Lock()
+	if s.closed.Get() == SOCKET_CLOSED {
+		s.mu.Unlock()
+		return nil
+	}
+	//先检查上限再写，避免写出一部分后丢弃剩余数据
+	if len(s.pending)+len(data) > DEFAULT_EVENT_LOOP_WRITE_BUFFER_LIMIT {
+		s.mu.Unlock()
+		s.log().Log(LOG_LEVEL_WARN, "tcp session write buffer overflow, discard data", "remote", s.remoteAddr, "size", len(data))
+		return NewStreamError("write", ErrWriteOverflow)
+	}
+	if len(s.pending) == 0 {
+		n, err := syscall.Write(s.fd, data)
+		if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
+			s.mu.Unlock()
+			return s.exception("write", err)
+		}
+		if n > 0 {
+			data = data[n:]
+		}
+		if len(data) == 0 {
+			s.mu.Unlock()
+			s.touch()
+			return nil
+		}
+		ev := syscall.EpollEvent{Events: eventLoopWriteEvents, Fd: int32(s.fd)}
+		if err = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, s.fd, &ev); err != nil && err != syscall.ENOENT {
+			s.mu.Unlock()
+			return s.exception("epoll", err)
+		}
+	}
+	s.pending = append(s.pending, data...)
+	s.mu.Unlock()
+	return nil
+}
+
+func (l *eventLoop) closeSession(s *BaseTCPEventLoopSession) {
+	if s.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		reason := s.closeState.reason()
+		s.log().Log(LOG_LEVEL_INFO, "tcp session closed", "remote", s.remoteAddr, "reason", reason)
+		s.mu.Lock()
+		syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, s.fd, nil)
+		syscall.Close(s.fd)
+		s.pending = nil
+		s.mu.Unlock()
+
+		l.mu.Lock()
+		if l.sessions[s.fd] == s {
+			delete(l.sessions, s.fd)
+		}
+		l.mu.Unlock()
+
+		notifyClose(s.IBaseTCPSessionHandle, reason)
+	}
+}
+
+func (l *eventLoop) closeExpired(now time.Time) {
+	l.mu.Lock()
+	var expired []*BaseTCPEventLoopSession
+	for _, s := range l.sessions {
+		if s.expired(now) {
+			expired = append(expired, s)
+		}
+	}
+	l.mu.Unlock()
+	for _, s := range expired {
+		s.exception("read", errEventLoopTimeout)
+	}
+}
+
+var errEventLoopTimeout = &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+//与 goroutine 模式不同，event loop 在出错后会主动关闭连接，避免 fd 持续触发事件
+func (s *BaseTCPEventLoopSession) exception(op string, err error) error {
+	if s.closed.Get() == SOCKET_CLOSED {
+		return NewStreamError(op, err)
+	}
+	se := s.closeState.record(op, err)
+	s.log().Log(LOG_LEVEL_DEBUG, "tcp session "+op+" failed", "remote", s.remoteAddr, "kind", se.Kind, "err", err)
+	s.IBaseTCPSessionHandle.OnException(se)
+	s.Close()
+	return se
+}
@@ -0,0 +1,196 @@
+// base_socket_event_loop_linux_test.go
+//go:build linux
+
+package gobase
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+type echoEventLoopServerHandle struct {
+	BaseTCPServerHandle
+	started AtomicInt32
+}
+
+func (h *echoEventLoopServerHandle) OnAcceptSession(s *BaseTCPEventLoopSession) IBaseTCPSessionHandle {
+	return &echoEventLoopSessionHandle{s: s, server: h}
+}
+
+type echoEventLoopSessionHandle struct {
+	BaseTCPSessionHandle
+	s      *BaseTCPEventLoopSession
+	server *echoEventLoopServerHandle
+}
+
+func (h *echoEventLoopSessionHandle) OnStart() {
+	h.server.started.Add(1)
+}
+
+func (h *echoEventLoopSessionHandle) OnRead(data []byte) {
+	h.s.Write(append([]byte(nil), data...))
+}
+
+func (h *echoEventLoopSessionHandle) OnException(err error) {
+	h.s.Close()
+}
+
+func Test_BaseTCPServerEventLoop(t *testing.T) {
+	s := &BaseTCPServer{IBaseTCPServerHandle: &echoEventLoopServerHandle{}}
+	if err := s.StartEventLoopByAddr("127.0.0.1:0", 2); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 5)
+	if _, err = conn.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "hello" {
+		t.Fatalf("unexpected echo: %q", p)
+	}
+}
+
+const benchIdleConnNum = 2000
+
+type idleGoroutineServerHandle struct {
+	BaseTCPServerHandle
+	mu       sync.Mutex
+	sessions []*BaseTCPSession
+	started  AtomicInt32
+}
+
+func (h *idleGoroutineServerHandle) OnAccept(c net.Conn) {
+	session := &BaseTCPSession{}
+	session.Conn = c
+	session.IBaseTCPStreamHandle = &BaseTCPSessionHandle{}
+	session.Start()
+	h.mu.Lock()
+	h.sessions = append(h.sessions, session)
+	h.mu.Unlock()
+	h.started.Add(1)
+}
+
+func (h *idleGoroutineServerHandle) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.sessions {
+		s.Close()
+	}
+	h.sessions = nil
+}
+
+func Benchmark_IdleConnMemoryGoroutine(b *testing.B) {
+	handle := &idleGoroutineServerHandle{}
+	s := &BaseTCPServer{IBaseTCPServerHandle: handle}
+	if err := s.StartByAddr("127.0.0.1:0"); err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	benchmarkIdleConnMemory(b, s.Addr().String(), &handle.started, handle.closeAll)
+}
+
+func Benchmark_IdleConnMemoryEventLoop(b *testing.B) {
+	handle := &echoEventLoopServerHandle{}
+	s := &BaseTCPServer{IBaseTCPServerHandle: handle}
+	if err := s.StartEventLoopByAddr("127.0.0.1:0", runtime.NumCPU()); err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	benchmarkIdleConnMemory(b, s.Addr().String(), &handle.started, func() {})
+}
+
+//每次迭代建立 benchIdleConnNum 个空闲连接，统计服务端每个连接占用的内存
+func benchmarkIdleConnMemory(b *testing.B, addr string, started *AtomicInt32, closeAll func()) {
+	var total uint64
+	for i := 0; i < b.N; i++ {
+		started.Set(0)
+		before := memInUse()
+		conns := make([]net.Conn, 0, benchIdleConnNum)
+		for j := 0; j < benchIdleConnNum; j++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			conns = append(conns, conn)
+		}
+		for started.Get() < benchIdleConnNum {
+			time.Sleep(time.Millisecond)
+		}
+		after := memInUse()
+		if after > before {
+			total += after - before
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+		closeAll()
+	}
+	b.ReportMetric(float64(total)/float64(b.N)/benchIdleConnNum, "bytes/conn")
+}
+
+func memInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse + m.StackInuse
+}
+
+type overflowEventLoopServerHandle struct {
+	BaseTCPServerHandle
+	errs chan error
+}
+
+func (h *overflowEventLoopServerHandle) OnAcceptSession(s *BaseTCPEventLoopSession) IBaseTCPSessionHandle {
+	return &overflowEventLoopSessionHandle{s: s, errs: h.errs}
+}
+
+type overflowEventLoopSessionHandle struct {
+	BaseTCPSessionHandle
+	s    *BaseTCPEventLoopSession
+	errs chan error
+}
+
+func (h *overflowEventLoopSessionHandle) OnStart() {
+	h.errs <- h.s.Write(make([]byte, DEFAULT_EVENT_LOOP_WRITE_BUFFER_LIMIT+1))
+	h.s.Write([]byte("ok"))
+}
+
+func Test_BaseTCPServerEventLoopOverflowWritesNothing(t *testing.T) {
+	handle := &overflowEventLoopServerHandle{errs: make(chan error, 1)}
+	s := &BaseTCPServer{IBaseTCPServerHandle: handle}
+	if err := s.StartEventLoopByAddr("127.0.0.1:0", 1); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := <-handle.errs; ClassifyError(err) != ERR_KIND_OVERFLOW {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	p := make([]byte, 2)
+	if _, err = io.ReadFull(conn, p); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "ok" {
+		t.Fatalf("overflowed write was partially sent: %q", p)
+	}
+}
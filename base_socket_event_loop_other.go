@@ -0,0 +1,37 @@
+// base_socket_event_loop_other.go
+//go:build !linux
+
+package gobase
+
+import (
+	"net"
+)
+
+type eventLoop struct {
+	logger Logger
+}
+
+func newEventLoop(logger Logger) (*eventLoop, error) {
+	return nil, ErrEventLoopNotSupported
+}
+
+func (l *eventLoop) register(conn net.Conn, handle IBaseTCPEventLoopServerHandle) error {
+	conn.Close()
+	return ErrEventLoopNotSupported
+}
+
+func (l *eventLoop) run() {
+}
+
+func (l *eventLoop) shutdown() {
+}
+
+func (l *eventLoop) stop() {
+}
+
+func (l *eventLoop) write(s *BaseTCPEventLoopSession, data []byte) error {
+	return ErrEventLoopNotSupported
+}
+
+func (l *eventLoop) closeSession(s *BaseTCPEventLoopSession) {
+}
@@ -0,0 +1,235 @@
+// base_socket_udp_batch.go
+package gobase
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const UDP_MAX_DATAGRAM_SIZE = 64 * 1024
+
+var ErrDatagramTruncated = errors.New("udp datagram truncated")
+
+//所有 UDP 读缓冲区都是 UDP_MAX_DATAGRAM_SIZE 大小，按 ReadBufferSize 截取使用
+var udpReadBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, UDP_MAX_DATAGRAM_SIZE)
+		return &buf
+	},
+}
+
+func getUDPReadBuffer(size int) []byte {
+	return (*udpReadBufferPool.Get().(*[]byte))[:size]
+}
+
+func putUDPReadBuffer(buf []byte) {
+	buf = buf[:cap(buf)]
+	udpReadBufferPool.Put(&buf)
+}
+
+//ipv4.PacketConn 和 ipv6.PacketConn 的 Message 是同一个类型
+type udpBatchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+func newUDPBatchConn(conn *net.UDPConn) udpBatchConn {
+	if udpConnIsIPv6(conn) {
+		return ipv6.NewPacketConn(conn)
+	}
+	return ipv4.NewPacketConn(conn)
+}
+
+//IPv6 或双栈 socket
+func udpConnIsIPv6(conn *net.UDPConn) bool {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok && addr.IP.To4() == nil && len(addr.IP) == net.IPv6len
+}
+
+func (s *BaseUDPStream) readBufferSize() int {
+	size := s.ReadBufferSize
+	if size <= 0 {
+		size = int(SOCKET_READ_BUFFER_SIZE)
+	}
+	if s.Fragmentation && size < s.fragmentSize() {
+		size = s.fragmentSize()
+	}
+	if size > UDP_MAX_DATAGRAM_SIZE {
+		size = UDP_MAX_DATAGRAM_SIZE
+	}
+	return size
+}
+
+//每个 socket 至少一个读 goroutine，不支持 SO_REUSEPORT 时多个 goroutine 读同一个 socket
+func (s *BaseUDPStream) startReaders() {
+	readers := s.Readers
+	if readers < len(s.readerConns) {
+		readers = len(s.readerConns)
+	}
+	if readers <= 0 {
+		readers = 1
+	}
+	for i := 0; i < readers; i++ {
+		conn := s.readerConns[i%len(s.readerConns)]
+		if s.BatchSize > 1 {
+			go s.batchReadLoop(conn, i == 0)
+		} else {
+			go s.readLoop(conn, i == 0)
+		}
+	}
+}
+
+func (s *BaseUDPStream) batchReadLoop(conn *net.UDPConn, primary bool) {
+	size := s.readBufferSize()
+	oob, parseDst := s.dstControl(conn)
+	msgs := make([]ipv4.Message, s.BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{getUDPReadBuffer(size)}
+		if oob != nil {
+			msgs[i].OOB = make([]byte, len(oob))
+		}
+	}
+	defer func() {
+		for i := range msgs {
+			putUDPReadBuffer(msgs[i].Buffers[0])
+		}
+	}()
+
+	bc := newUDPBatchConn(conn)
+	for {
+		n, err := bc.ReadBatch(msgs, 0)
+		if err != nil {
+			if s.onReadError(err, primary) {
+				continue
+			}
+			break
+		}
+		for i := 0; i < n; i++ {
+			addr, _ := msgs[i].Addr.(*net.UDPAddr)
+			s.onDatagram(msgs[i].Buffers[0][:msgs[i].N], msgs[i].Flags, addr, parseDst(msgs[i].OOB[:msgs[i].NN]))
+		}
+	}
+}
+
+//只有第一个读 goroutine 在关闭后回调 OnException，和单 socket 时的行为一致；返回 true 时读循环继续
+func (s *BaseUDPStream) onReadError(err error, primary bool) bool {
+	open := s.closed.Get() == SOCKET_OPEN
+	if open && s.onUnreachable != nil && isUDPUnreachable(err) {
+		s.onUnreachable(err)
+		return true
+	}
+	if open {
+		se := s.closeState.record("read", err)
+		s.log().Log(LOG_LEVEL_DEBUG, "udp read failed", "local", s.Conn.LocalAddr(), "kind", se.Kind, "err", err)
+	}
+	if s.IBaseUDPStreamHandle != nil && (primary || open) {
+		s.IBaseUDPStreamHandle.OnException(NewStreamError("read", err))
+	}
+	return false
+}
+
+//被截断的数据报直接丢弃，以 ERR_KIND_OVERFLOW 回调 OnException，读循环继续
+func (s *BaseUDPStream) onDatagram(data []byte, flags int, addr *net.UDPAddr, dst net.IP) {
+	if s.limiter != nil && !s.limiter.allow(addr, len(data)) {
+		return
+	}
+	if udpTruncated(flags) {
+		s.log().Log(LOG_LEVEL_WARN, "udp datagram truncated", "from", addr, "buffer", len(data))
+		if s.IBaseUDPStreamHandle != nil {
+			s.IBaseUDPStreamHandle.OnException(&StreamError{
+				Kind: ERR_KIND_OVERFLOW,
+				Op:   "read",
+				Err:  fmt.Errorf("%w, from %s, buffer size %d", ErrDatagramTruncated, addr, len(data)),
+			})
+		}
+		return
+	}
+	owned := false
+	if s.Sealer != nil {
+		opened, ok := s.Sealer.Open(data)
+		if !ok {
+			s.log().Log(LOG_LEVEL_DEBUG, "udp datagram failed authentication, discard", "from", addr, "size", len(data))
+			return
+		}
+		data, owned = opened, true
+	}
+	if s.Fragmentation {
+		var reassembled bool
+		if data, reassembled = s.reassemble(data, addr); data == nil {
+			return
+		}
+		owned = owned || reassembled
+	}
+	if s.demux != nil {
+		if !s.ReuseReadBuffer && !owned {
+			data = append([]byte(nil), data...)
+		}
+		s.demux(data, addr)
+		return
+	}
+	if s.IBaseUDPStreamHandle != nil {
+		if !s.ReuseReadBuffer && !owned {
+			data = append([]byte(nil), data...)
+		}
+		if s.dstHandle != nil {
+			s.dstHandle.OnReadWithDst(data, addr, dst)
+		} else {
+			s.IBaseUDPStreamHandle.OnRead(data, addr)
+		}
+	}
+}
+
+func (s *BaseUDPStream) batchWriteLoop() {
+	batch := make([]*UDPMsg, 0, s.BatchSize)
+	msgs := make([]ipv4.Message, s.BatchSize)
+	bc := newUDPBatchConn(s.Conn.(*net.UDPConn))
+	for {
+		select {
+		case udpMsg := <-s.writeChan:
+			batch = append(batch[:0], udpMsg)
+		fill:
+			for len(batch) < s.BatchSize {
+				select {
+				case udpMsg = <-s.writeChan:
+					batch = append(batch, udpMsg)
+				default:
+					break fill
+				}
+			}
+			s.writeBatch(bc, batch, msgs)
+			for range batch {
+				s.writeEmptyWait.Done()
+			}
+		case <-s.writtingLoopCloseChan:
+			return
+		}
+	}
+}
+
+//发送失败的数据报记录日志后跳过，继续发送后面的
+func (s *BaseUDPStream) writeBatch(bc udpBatchConn, batch []*UDPMsg, msgs []ipv4.Message) {
+	for i, udpMsg := range batch {
+		msgs[i] = ipv4.Message{Buffers: [][]byte{udpMsg.data}, Addr: udpMsg.destAddr}
+	}
+	for sent := 0; sent < len(batch); {
+		n, err := bc.WriteBatch(msgs[sent:len(batch)], 0)
+		//第一个消息就发送失败时 n 为 -1
+		if n < 0 {
+			n = 0
+		}
+		sent += n
+		if err != nil && sent < len(batch) {
+			udpMsg := batch[sent]
+			s.log().Log(LOG_LEVEL_WARN, "udp write failed", "dest", udpMsg.destAddr, "size", len(udpMsg.data), "err", err)
+			sent++
+		}
+	}
+	for i := range batch {
+		msgs[i] = ipv4.Message{}
+	}
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+// base_socket_udp_batch_linux.go
+package gobase
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+//readers > 1 时打开 readers 个 SO_REUSEPORT socket，由内核按四元组分摊数据报
+func listenUDPReaders(network string, addr *net.UDPAddr, readers int) ([]*net.UDPConn, error) {
+	if readers <= 1 {
+		conn, err := net.ListenUDP(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	conns := make([]*net.UDPConn, 0, readers)
+	for i := 0; i < readers; i++ {
+		pc, err := lc.ListenPacket(context.Background(), network, addr.String())
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conn := pc.(*net.UDPConn)
+		conns = append(conns, conn)
+		//端口为 0 时后面的 socket 绑定到第一个 socket 实际分配的端口
+		addr = conn.LocalAddr().(*net.UDPAddr)
+	}
+	return conns, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+// base_socket_udp_batch_other.go
+package gobase
+
+import (
+	"net"
+)
+
+//没有 SO_REUSEPORT 负载均衡时只打开一个 socket，由多个 goroutine 共同读取
+func listenUDPReaders(network string, addr *net.UDPAddr, readers int) ([]*net.UDPConn, error) {
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []*net.UDPConn{conn}, nil
+}
@@ -0,0 +1,123 @@
+// base_socket_udp_batch_test.go
+package gobase
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type udpEchoHandle struct {
+	BaseUDPServerHandle
+	server     *BaseUDPServer
+	exceptions chan error
+}
+
+func (h *udpEchoHandle) OnRead(data []byte, addr *net.UDPAddr) {
+	h.server.WriteToUDP(data, addr)
+}
+
+func (h *udpEchoHandle) OnException(err error) {
+	h.exceptions <- err
+}
+
+func startUDPEchoServer(t *testing.T, config func(s *BaseUDPServer)) (*BaseUDPServer, *udpEchoHandle) {
+	s := &BaseUDPServer{}
+	h := &udpEchoHandle{server: s, exceptions: make(chan error, 16)}
+	s.IBaseUDPStreamHandle = h
+	config(s)
+	if err := s.Start("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, h
+}
+
+func Test_UDPBatchEcho(t *testing.T) {
+	s, _ := startUDPEchoServer(t, func(s *BaseUDPServer) {
+		s.ReadBufferSize = UDP_MAX_DATAGRAM_SIZE
+		s.BatchSize = 8
+		s.Readers = 2
+	})
+	conn, err := net.DialUDP("udp4", nil, s.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	const count = 20
+	for i := 0; i < count; i++ {
+		msg := make([]byte, 4000)
+		msg[0] = byte(i)
+		conn.Write(msg)
+	}
+	seen := make(map[byte]bool)
+	buf := make([]byte, UDP_MAX_DATAGRAM_SIZE)
+	for len(seen) < count {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("received %d of %d datagrams: %s", len(seen), count, err)
+		}
+		if n != 4000 {
+			t.Fatalf("unexpected datagram size %d", n)
+		}
+		seen[buf[0]] = true
+	}
+}
+
+func Test_UDPTruncated(t *testing.T) {
+	for _, batchSize := range []int{1, 4} {
+		s, h := startUDPEchoServer(t, func(s *BaseUDPServer) {
+			s.ReadBufferSize = 16
+			s.BatchSize = batchSize
+		})
+		conn, err := net.DialUDP("udp4", nil, s.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		conn.Write(make([]byte, 100))
+		select {
+		case err := <-h.exceptions:
+			if !IsOverflowError(err) || !errors.Is(err, ErrDatagramTruncated) {
+				t.Fatalf("batch size %d: unexpected exception %v", batchSize, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("batch size %d: truncation not reported", batchSize)
+		}
+
+		//读循环继续工作
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "ping" {
+			t.Fatalf("batch size %d: unexpected echo %q, %v", batchSize, buf[:n], err)
+		}
+	}
+}
+
+func Test_UDPBatchWriteOversized(t *testing.T) {
+	s, _ := startUDPEchoServer(t, func(s *BaseUDPServer) {
+		s.BatchSize = 4
+	})
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	//超过数据报最大长度的消息发送失败后跳过，后面的照常发送
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	s.WriteTo(make([]byte, 70000), addr)
+	s.WriteTo([]byte("ping"), addr)
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("unexpected datagram %q, %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,165 @@
+// base_socket_udp_client.go
+package gobase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+var ErrUDPNotConnected = errors.New("udp client not connected")
+var ErrUDPPortUnreachable = errors.New("udp destination unreachable")
+
+//连接模式的 socket 写时不能带目的地址
+func (s *BaseUDPStream) writeMsg(m *UDPMsg) (int, error) {
+	if m.destAddr == nil {
+		return s.Conn.Write(m.data)
+	}
+	return s.Conn.(*net.UDPConn).WriteTo(m.data, m.destAddr)
+}
+
+//连接模式下对端端口不可达的 ICMP 由下一次读返回，Linux 上为 ECONNREFUSED，Windows 上为 ECONNRESET
+func isUDPUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+//remote: "10.0.0.1:53"、"[::1]:53"，使用系统分配的本地端口，
+//只接收 remote 发来的数据报，对端不可达时以 ErrUDPPortUnreachable 回调 OnException，读循环继续
+func (c *BaseUDPClient) Connect(remote string) error {
+	raddr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return err
+	}
+	network := "udp6"
+	if raddr.IP.To4() != nil {
+		network = "udp4"
+	}
+	conn, err := net.DialUDP(network, nil, raddr)
+	if err != nil {
+		c.log().Log(LOG_LEVEL_ERROR, "udp connect failed", "remote", raddr, "err", err)
+		return err
+	}
+	c.log().Log(LOG_LEVEL_INFO, "udp connect successed", "local", conn.LocalAddr(), "remote", raddr)
+	c.Conn = conn
+	c.readerConns = []*net.UDPConn{conn}
+	c.remote = raddr
+	c.demux = c.dispatch
+	c.onUnreachable = c.unreachable
+	c.run()
+	return nil
+}
+
+func (c *BaseUDPClient) RemoteUDPAddr() *net.UDPAddr {
+	return c.remote
+}
+
+//发送到 Connect 的远端，实现 io.Writer，数据进入发送队列即返回 len(data)
+func (c *BaseUDPClient) Write(data []byte) (int, error) {
+	if c.remote == nil {
+		return 0, ErrUDPNotConnected
+	}
+	if c.closed.Get() == SOCKET_OPEN {
+		c.WriteTo(data, nil)
+	}
+	return len(data), nil
+}
+
+func (c *BaseUDPClient) WriteString(data string) (int, error) {
+	return c.Write([]byte(data))
+}
+
+func (c *BaseUDPClient) dispatch(data []byte, addr *net.UDPAddr) {
+	if !addr.IP.Equal(c.remote.IP) || addr.Port != c.remote.Port {
+		c.log().Log(LOG_LEVEL_DEBUG, "udp datagram from unexpected source, discard", "from", addr, "remote", c.remote)
+		return
+	}
+	if c.deliverResponse(data) {
+		return
+	}
+	if c.IBaseUDPStreamHandle != nil {
+		c.IBaseUDPStreamHandle.OnRead(data, addr)
+	}
+}
+
+func (c *BaseUDPClient) unreachable(err error) {
+	se := &StreamError{Kind: ERR_KIND_RESET, Op: "read", Err: fmt.Errorf("%w: %w", ErrUDPPortUnreachable, err)}
+	c.log().Log(LOG_LEVEL_DEBUG, "udp remote unreachable", "remote", c.remote, "err", err)
+	c.waitersMu.Lock()
+	waiters := c.waiters
+	c.waiters = nil
+	c.waitersMu.Unlock()
+	for _, w := range waiters {
+		w.errCh <- se
+	}
+	if c.IBaseUDPStreamHandle != nil {
+		c.IBaseUDPStreamHandle.OnException(se)
+	}
+}
+
+type udpWaiter struct {
+	match  func(resp []byte) bool
+	respCh chan []byte
+	errCh  chan error
+}
+
+//被 Request 认领的响应不再回调 OnRead
+func (c *BaseUDPClient) deliverResponse(data []byte) bool {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+	for i, w := range c.waiters {
+		if w.match == nil || w.match(data) {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			w.respCh <- data
+			return true
+		}
+	}
+	return false
+}
+
+func (c *BaseUDPClient) removeWaiter(w *udpWaiter) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+//发送 req 并等待 match 返回 true 的响应（match 为 nil 时接受第一个数据报），
+//每 retransmit 没有收到响应时重发一次，ctx 结束时返回超时错误；需要先 Connect
+func (c *BaseUDPClient) Request(ctx context.Context, req []byte, match func(resp []byte) bool, retransmit time.Duration) ([]byte, error) {
+	if c.remote == nil {
+		return nil, ErrUDPNotConnected
+	}
+	w := &udpWaiter{match: match, respCh: make(chan []byte, 1), errCh: make(chan error, 1)}
+	c.waitersMu.Lock()
+	c.waiters = append(c.waiters, w)
+	c.waitersMu.Unlock()
+	defer c.removeWaiter(w)
+
+	var resend <-chan time.Time
+	if retransmit > 0 {
+		ticker := time.NewTicker(retransmit)
+		defer ticker.Stop()
+		resend = ticker.C
+	}
+	c.Write(req)
+	for {
+		select {
+		case resp := <-w.respCh:
+			return resp, nil
+		case err := <-w.errCh:
+			return nil, err
+		case <-resend:
+			c.log().Log(LOG_LEVEL_DEBUG, "udp request retransmit", "remote", c.remote, "size", len(req))
+			c.Write(req)
+		case <-ctx.Done():
+			return nil, NewStreamError("request", ctx.Err())
+		}
+	}
+}
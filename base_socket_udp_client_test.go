@@ -0,0 +1,161 @@
+// base_socket_udp_client_test.go
+package gobase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+//BaseUDPClient 可以作为 io.Writer 使用
+var (
+	_ io.Writer       = &BaseUDPClient{}
+	_ io.StringWriter = &BaseUDPClient{}
+)
+
+type udpClientRecordHandle struct {
+	BaseUDPClientHandle
+	reads      chan []byte
+	exceptions chan error
+}
+
+func newUDPClientRecordHandle() *udpClientRecordHandle {
+	return &udpClientRecordHandle{reads: make(chan []byte, 16), exceptions: make(chan error, 16)}
+}
+
+func (h *udpClientRecordHandle) OnRead(data []byte, addr *net.UDPAddr) {
+	h.reads <- data
+}
+
+func (h *udpClientRecordHandle) OnException(err error) {
+	h.exceptions <- err
+}
+
+func connectUDPClient(t *testing.T, remote string) (*BaseUDPClient, *udpClientRecordHandle) {
+	h := newUDPClientRecordHandle()
+	c := &BaseUDPClient{}
+	c.IBaseUDPStreamHandle = h
+	if err := c.Connect(remote); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, h
+}
+
+func Test_UDPClientConnectEcho(t *testing.T) {
+	s, _ := startUDPEchoServer(t, func(s *BaseUDPServer) {})
+	c, h := connectUDPClient(t, s.LocalUDPAddr().String())
+	if c.LocalUDPAddr().Port == 0 {
+		t.Fatal("expect ephemeral local port")
+	}
+	if _, err := c.WriteString("ping"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-h.reads:
+		if string(data) != "ping" {
+			t.Fatalf("unexpected echo %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("echo not received")
+	}
+}
+
+func Test_UDPClientFilterSource(t *testing.T) {
+	remote, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	c, h := connectUDPClient(t, remote.LocalAddr().String())
+
+	other, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	//内核对连接模式的 socket 已经过滤了其他来源，这里直接验证 dispatch
+	c.dispatch([]byte("spoof"), other.LocalAddr().(*net.UDPAddr))
+	remote.WriteToUDP([]byte("real"), c.LocalUDPAddr())
+	select {
+	case data := <-h.reads:
+		if string(data) != "real" {
+			t.Fatalf("datagram from other source delivered: %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("datagram not received")
+	}
+}
+
+func Test_UDPClientPortUnreachable(t *testing.T) {
+	dead, _ := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	addr := dead.LocalAddr().String()
+	dead.Close()
+
+	c, h := connectUDPClient(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.Request(ctx, []byte("anyone?"), nil, 100*time.Millisecond)
+	if !errors.Is(err, ErrUDPPortUnreachable) || ClassifyError(err) != ERR_KIND_RESET {
+		t.Fatalf("unexpected error %v", err)
+	}
+	select {
+	case err := <-h.exceptions:
+		if !errors.Is(err, ErrUDPPortUnreachable) {
+			t.Fatalf("unexpected exception %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("unreachable not reported")
+	}
+	//读循环没有因为 ICMP 结束
+	if c.closed.Get() != SOCKET_OPEN {
+		t.Fatal("client should stay open")
+	}
+}
+
+func Test_UDPClientRequestRetransmit(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	//丢掉第一个请求，回复第二个
+	go func() {
+		buf := make([]byte, 64)
+		for i := 0; ; i++ {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if i > 0 {
+				server.WriteToUDP(append([]byte("re:"), buf[:n]...), addr)
+			}
+		}
+	}()
+	c, h := connectUDPClient(t, server.LocalAddr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.Request(ctx, []byte("query"), func(resp []byte) bool {
+		return string(resp) == "re:query"
+	}, 50*time.Millisecond)
+	if err != nil || string(resp) != "re:query" {
+		t.Fatalf("unexpected response %q, err %v", resp, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = c.Request(ctx, []byte("query"), func(resp []byte) bool { return false }, 0)
+	if !IsTimeoutError(err) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	//未被认领的响应交给 OnRead
+	select {
+	case <-h.reads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unmatched response not delivered to OnRead")
+	}
+}
@@ -0,0 +1,250 @@
+// base_socket_udp_fragment.go
+package gobase
+
+import (
+	"container/list"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_UDP_FRAGMENT_SIZE           = 1200 //IPv6 最小 MTU 1280 减去 IP/UDP 头
+	DEFAULT_UDP_REASSEMBLY_TIMEOUT      = 5    //unit: second
+	DEFAULT_UDP_REASSEMBLY_MAX_BYTES    = 4 * 1024 * 1024
+	DEFAULT_UDP_REASSEMBLY_MAX_MESSAGES = 1024
+	UDP_FRAGMENT_HEADER_SIZE            = 10
+	UDP_FRAGMENT_MAX_COUNT              = 0xffff
+
+	udpFragmentMagic = 0xfa
+	//计入 MaxReassemblyBytes 的每个消息的固定开销和每个分片槽位的开销
+	udpPartialMessageOverhead = 128
+	udpFragmentSlotSize       = 24
+)
+
+//分片层的计数，Expired 和 Evicted 都是没有收齐就被丢弃的消息
+type UDPFragmentStats struct {
+	Reassembled  uint64 //收齐并交付的多分片消息
+	Expired      uint64 //超过 ReassemblyTimeOut 没有收齐
+	Evicted      uint64 //缓冲超过 MaxReassemblyBytes 或 MaxReassemblyMessages 时被淘汰
+	Invalid      uint64 //没有分片头、分片头不合法或多分片消息中没有数据的数据报
+	Pending      int    //正在重组的消息数
+	PendingBytes int
+}
+
+//分片头: magic(1) 保留(1) count(2) index(2) msgID(4)，大端
+type udpFragmentHeader struct {
+	count uint16
+	index uint16
+	msgID uint32
+}
+
+func parseUDPFragmentHeader(data []byte) (udpFragmentHeader, bool) {
+	if len(data) < UDP_FRAGMENT_HEADER_SIZE || data[0] != udpFragmentMagic {
+		return udpFragmentHeader{}, false
+	}
+	h := udpFragmentHeader{
+		count: binary.BigEndian.Uint16(data[2:]),
+		index: binary.BigEndian.Uint16(data[4:]),
+		msgID: binary.BigEndian.Uint32(data[6:]),
+	}
+	return h, h.count > 0 && h.index < h.count
+}
+
+func (h udpFragmentHeader) put(buf []byte) {
+	buf[0] = udpFragmentMagic
+	buf[1] = 0
+	binary.BigEndian.PutUint16(buf[2:], h.count)
+	binary.BigEndian.PutUint16(buf[4:], h.index)
+	binary.BigEndian.PutUint32(buf[6:], h.msgID)
+}
+
+func (s *BaseUDPStream) fragmentSize() int {
+	if s.FragmentSize <= UDP_FRAGMENT_HEADER_SIZE+UDP_SEAL_OVERHEAD {
+		return DEFAULT_UDP_FRAGMENT_SIZE
+	}
+	return s.FragmentSize
+}
+
+//按 fragmentSize 切分，消息不超过一个分片时也带分片头
+func (s *BaseUDPStream) fragment(data []byte) [][]byte {
+	payload := s.fragmentSize() - UDP_FRAGMENT_HEADER_SIZE
+	if s.Sealer != nil {
+		payload -= UDP_SEAL_OVERHEAD
+	}
+	count := (len(data) + payload - 1) / payload
+	if count == 0 {
+		count = 1
+	}
+	if count > UDP_FRAGMENT_MAX_COUNT {
+		return nil
+	}
+	h := udpFragmentHeader{count: uint16(count), msgID: s.fragmentID.Add(1)}
+	fragments := make([][]byte, count)
+	for i := range fragments {
+		end := (i + 1) * payload
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[i*payload : end]
+		buf := make([]byte, UDP_FRAGMENT_HEADER_SIZE+len(chunk))
+		h.index = uint16(i)
+		h.put(buf)
+		copy(buf[UDP_FRAGMENT_HEADER_SIZE:], chunk)
+		fragments[i] = buf
+	}
+	return fragments
+}
+
+//返回完整的消息，owned 为 true 时 data 是新分配的，不需要再拷贝；消息还不完整时返回 nil
+func (s *BaseUDPStream) reassemble(data []byte, addr *net.UDPAddr) (msg []byte, owned bool) {
+	h, ok := parseUDPFragmentHeader(data)
+	if !ok {
+		s.reassembler.invalid()
+		s.log().Log(LOG_LEVEL_DEBUG, "udp datagram without valid fragment header, discard", "from", addr, "size", len(data))
+		return nil, false
+	}
+	if h.count == 1 {
+		return data[UDP_FRAGMENT_HEADER_SIZE:], false
+	}
+	//发送端只有最后一个分片可能较短，空分片只会来自伪造的数据报
+	if len(data) == UDP_FRAGMENT_HEADER_SIZE {
+		s.reassembler.invalid()
+		s.log().Log(LOG_LEVEL_DEBUG, "udp fragment without payload, discard", "from", addr, "count", h.count)
+		return nil, false
+	}
+	timeOut := s.ReassemblyTimeOut
+	if timeOut <= 0 {
+		timeOut = DEFAULT_UDP_REASSEMBLY_TIMEOUT
+	}
+	maxBytes := s.MaxReassemblyBytes
+	if maxBytes <= 0 {
+		maxBytes = DEFAULT_UDP_REASSEMBLY_MAX_BYTES
+	}
+	maxMessages := s.MaxReassemblyMessages
+	if maxMessages <= 0 {
+		maxMessages = DEFAULT_UDP_REASSEMBLY_MAX_MESSAGES
+	}
+	msg = s.reassembler.add(udpReassemblyKey{from: addr.AddrPort(), msgID: h.msgID}, h,
+		data[UDP_FRAGMENT_HEADER_SIZE:], timeOut*time.Second, maxBytes, maxMessages)
+	return msg, true
+}
+
+func (s *BaseUDPStream) FragmentStats() UDPFragmentStats {
+	return s.reassembler.stats()
+}
+
+type udpReassemblyKey struct {
+	from  netip.AddrPort
+	msgID uint32
+}
+
+type udpPartialMessage struct {
+	key       udpReassemblyKey
+	fragments [][]byte
+	received  int
+	size      int //分片数据和分片表的开销
+	expireAt  time.Time
+}
+
+//按创建顺序排列，超时相同，所以队头最先过期，缓冲满时也从队头淘汰
+type udpReassembler struct {
+	mu      sync.Mutex
+	order   list.List
+	pending map[udpReassemblyKey]*list.Element
+	bytes   int
+	counts  UDPFragmentStats
+}
+
+func (r *udpReassembler) invalid() {
+	r.mu.Lock()
+	r.counts.Invalid++
+	r.mu.Unlock()
+}
+
+func (r *udpReassembler) add(key udpReassemblyKey, h udpFragmentHeader, payload []byte, timeOut time.Duration, maxBytes int, maxMessages int) []byte {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+
+	e, ok := r.pending[key]
+	if !ok {
+		//先按分片表的大小检查上限，再分配
+		overhead := udpPartialMessageOverhead + int(h.count)*udpFragmentSlotSize
+		if overhead+len(payload) > maxBytes {
+			r.counts.Evicted++
+			return nil
+		}
+		for r.order.Len() > 0 && (r.order.Len() >= maxMessages || r.bytes+overhead > maxBytes) {
+			r.remove(r.order.Front().Value.(*udpPartialMessage))
+			r.counts.Evicted++
+		}
+		if r.pending == nil {
+			r.pending = make(map[udpReassemblyKey]*list.Element)
+		}
+		e = r.order.PushBack(&udpPartialMessage{key: key, fragments: make([][]byte, h.count), size: overhead, expireAt: now.Add(timeOut)})
+		r.pending[key] = e
+		r.bytes += overhead
+	}
+	m := e.Value.(*udpPartialMessage)
+	if int(h.count) != len(m.fragments) {
+		r.counts.Invalid++
+		return nil
+	}
+	if m.fragments[h.index] != nil {
+		return nil
+	}
+	//单个消息就超过上限时，淘汰到它自己为止
+	for r.bytes+len(payload) > maxBytes && r.order.Len() > 0 {
+		oldest := r.order.Front().Value.(*udpPartialMessage)
+		r.remove(oldest)
+		r.counts.Evicted++
+		if oldest == m {
+			return nil
+		}
+	}
+	m.fragments[h.index] = append([]byte(nil), payload...)
+	m.received++
+	m.size += len(payload)
+	r.bytes += len(payload)
+	if m.received < len(m.fragments) {
+		return nil
+	}
+	r.remove(m)
+	r.counts.Reassembled++
+	msg := make([]byte, 0, m.size)
+	for _, fragment := range m.fragments {
+		msg = append(msg, fragment...)
+	}
+	return msg
+}
+
+func (r *udpReassembler) expire(now time.Time) {
+	for e := r.order.Front(); e != nil; e = r.order.Front() {
+		m := e.Value.(*udpPartialMessage)
+		if now.Before(m.expireAt) {
+			return
+		}
+		r.remove(m)
+		r.counts.Expired++
+	}
+}
+
+func (r *udpReassembler) remove(m *udpPartialMessage) {
+	r.order.Remove(r.pending[m.key])
+	delete(r.pending, m.key)
+	r.bytes -= m.size
+}
+
+func (r *udpReassembler) stats() UDPFragmentStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(time.Now())
+	stats := r.counts
+	stats.Pending = r.order.Len()
+	stats.PendingBytes = r.bytes
+	return stats
+}
@@ -0,0 +1,118 @@
+// base_socket_udp_fragment_test.go
+package gobase
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_UDPFragmentEcho(t *testing.T) {
+	s, _ := startUDPEchoServer(t, func(s *BaseUDPServer) {
+		s.Fragmentation = true
+		s.FragmentSize = 512
+	})
+	h := newUDPClientRecordHandle()
+	c := &BaseUDPClient{}
+	c.IBaseUDPStreamHandle = h
+	c.Fragmentation = true
+	c.FragmentSize = 512
+	if err := c.Connect(s.LocalUDPAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	large := bytes.Repeat([]byte("0123456789"), 3000)
+	c.Write(large)
+	c.WriteString("small")
+	got := map[int]bool{}
+	for len(got) < 2 {
+		select {
+		case data := <-h.reads:
+			if len(data) == len(large) && !bytes.Equal(data, large) {
+				t.Fatal("reassembled message corrupted")
+			}
+			got[len(data)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of 2 messages", len(got))
+		}
+	}
+	if !got[len(large)] || !got[len("small")] {
+		t.Fatalf("unexpected messages %v", got)
+	}
+	if stats := s.FragmentStats(); stats.Reassembled != 1 || stats.Pending != 0 {
+		t.Fatalf("unexpected server stats %+v", stats)
+	}
+}
+
+func Test_UDPReassemblyExpireAndEvict(t *testing.T) {
+	//每个 3 分片的消息有 128+3*24 字节的分片表开销
+	s := &BaseUDPStream{Fragmentation: true, FragmentSize: 110, MaxReassemblyBytes: 650}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	first := s.fragment(make([]byte, 300))
+	second := s.fragment(make([]byte, 300))
+	if len(first) != 3 {
+		t.Fatalf("expect 3 fragments, got %d", len(first))
+	}
+	s.reassemble(first[0], addr)
+	s.reassemble(first[1], addr)
+	//第二个消息的分片放不下，淘汰第一个
+	s.reassemble(second[0], addr)
+	if stats := s.FragmentStats(); stats.Evicted != 1 || stats.Pending != 1 || stats.PendingBytes != 300 {
+		t.Fatalf("unexpected stats after evict %+v", stats)
+	}
+	if msg, _ := s.reassemble(first[2], addr); msg != nil {
+		t.Fatal("evicted message should not complete")
+	}
+
+	s.ReassemblyTimeOut = 0
+	s.reassembler.mu.Lock()
+	for e := s.reassembler.order.Front(); e != nil; e = e.Next() {
+		e.Value.(*udpPartialMessage).expireAt = time.Now()
+	}
+	s.reassembler.mu.Unlock()
+	if stats := s.FragmentStats(); stats.Expired != 2 || stats.Pending != 0 || stats.PendingBytes != 0 {
+		t.Fatalf("unexpected stats after expire %+v", stats)
+	}
+	if msg, _ := s.reassemble([]byte("no header"), addr); msg != nil || s.FragmentStats().Invalid != 1 {
+		t.Fatal("datagram without header should be discarded")
+	}
+}
+
+func Test_UDPReassemblyBoundsForgedFragments(t *testing.T) {
+	s := &BaseUDPStream{Fragmentation: true, MaxReassemblyMessages: 16}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	datagram := func(msgID uint32, payload int) []byte {
+		buf := make([]byte, UDP_FRAGMENT_HEADER_SIZE+payload)
+		udpFragmentHeader{count: UDP_FRAGMENT_MAX_COUNT, msgID: msgID}.put(buf)
+		return buf
+	}
+
+	//只有分片头的数据报不创建重组状态
+	for i := 0; i < 100; i++ {
+		s.reassemble(datagram(uint32(i), 0), addr)
+	}
+	if stats := s.FragmentStats(); stats.Pending != 0 || stats.Invalid != 100 {
+		t.Fatalf("header-only fragments should be discarded %+v", stats)
+	}
+
+	//分片表计入 MaxReassemblyBytes，消息数不超过 MaxReassemblyMessages
+	for i := 0; i < 100; i++ {
+		s.reassemble(datagram(uint32(i), 1), addr)
+	}
+	stats := s.FragmentStats()
+	if stats.Pending > 2 || stats.PendingBytes > DEFAULT_UDP_REASSEMBLY_MAX_BYTES || stats.Evicted != uint64(100-stats.Pending) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	small := &BaseUDPStream{Fragmentation: true, MaxReassemblyMessages: 16}
+	for i := 0; i < 100; i++ {
+		buf := make([]byte, UDP_FRAGMENT_HEADER_SIZE+1)
+		udpFragmentHeader{count: 2, msgID: uint32(i)}.put(buf)
+		small.reassemble(buf, addr)
+	}
+	if stats := small.FragmentStats(); stats.Pending != 16 || stats.Evicted != 84 {
+		t.Fatalf("pending messages not capped %+v", stats)
+	}
+}
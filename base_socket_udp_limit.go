@@ -0,0 +1,183 @@
+// base_socket_udp_limit.go
+package gobase
+
+import (
+	"container/list"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const DEFAULT_UDP_LIMIT_MAX_SOURCES = 65536
+
+type UDPDropReason int
+
+const (
+	UDP_DROP_SOURCE_RATE   UDPDropReason = iota //单个来源 IP 超过 PerSourceRate
+	UDP_DROP_GLOBAL_RATE                        //所有来源合计超过 GlobalRate
+	UDP_DROP_AMPLIFICATION                      //发给未验证来源的字节数超过收到的 AmplificationFactor 倍
+)
+
+func (r UDPDropReason) String() string {
+	switch r {
+	case UDP_DROP_SOURCE_RATE:
+		return "source-rate"
+	case UDP_DROP_GLOBAL_RATE:
+		return "global-rate"
+	case UDP_DROP_AMPLIFICATION:
+		return "amplification"
+	}
+	return "unknown"
+}
+
+type UDPRateLimitStats struct {
+	SourceDropped        uint64
+	GlobalDropped        uint64
+	AmplificationDropped uint64
+	Evicted              uint64 //来源表满时被淘汰的来源，淘汰后 Verify 状态也被清除
+	Sources              int
+}
+
+//设置到 BaseUDPServer.RateLimiter，数据报在进入 OnRead/会话之前按来源 IP 限速
+type UDPRateLimiter struct {
+	PerSourceRate       float64 //每个来源 IP 每秒的数据报数，0 表示不限制
+	PerSourceBurst      int     //0 时为 PerSourceRate
+	GlobalRate          float64 //所有来源每秒的数据报数，0 表示不限制
+	GlobalBurst         int     //0 时为 GlobalRate
+	MaxSources          int     //跟踪的来源 IP 数上限，满时淘汰最久没有数据报的来源，0 时为 DEFAULT_UDP_LIMIT_MAX_SOURCES
+	AmplificationFactor int     //>0 时对没有 Verify 的来源，累计发送字节数不超过累计收到字节数的 AmplificationFactor 倍
+	//在读写 goroutine 中回调，不能阻塞
+	OnDrop func(addr *net.UDPAddr, reason UDPDropReason)
+
+	mu      sync.Mutex
+	global  tokenBucket
+	sources map[netip.Addr]*list.Element
+	lru     list.List
+	counts  UDPRateLimitStats
+}
+
+type udpSource struct {
+	addr     netip.Addr
+	bucket   tokenBucket
+	received int
+	sent     int
+	verified bool
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	capacity := float64(burst)
+	if capacity <= 0 {
+		capacity = rate
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//确认 addr 的来源 IP 是真实的（例如完成了握手或 cookie 校验），之后不再限制响应大小
+func (l *UDPRateLimiter) Verify(addr *net.UDPAddr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.source(udpSourceAddr(addr)).verified = true
+}
+
+func (l *UDPRateLimiter) Stats() UDPRateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := l.counts
+	stats.Sources = l.lru.Len()
+	return stats
+}
+
+func udpSourceAddr(addr *net.UDPAddr) netip.Addr {
+	ip, _ := netip.AddrFromSlice(addr.IP)
+	return ip.Unmap()
+}
+
+//调用时持有 l.mu，返回的来源移到 LRU 队尾
+func (l *UDPRateLimiter) source(ip netip.Addr) *udpSource {
+	if e, ok := l.sources[ip]; ok {
+		l.lru.MoveToBack(e)
+		return e.Value.(*udpSource)
+	}
+	if l.sources == nil {
+		l.sources = make(map[netip.Addr]*list.Element)
+	}
+	maxSources := l.MaxSources
+	if maxSources <= 0 {
+		maxSources = DEFAULT_UDP_LIMIT_MAX_SOURCES
+	}
+	for l.lru.Len() >= maxSources {
+		oldest := l.lru.Front()
+		delete(l.sources, oldest.Value.(*udpSource).addr)
+		l.lru.Remove(oldest)
+		l.counts.Evicted++
+	}
+	src := &udpSource{addr: ip}
+	l.sources[ip] = l.lru.PushBack(src)
+	return src
+}
+
+func (l *UDPRateLimiter) allow(addr *net.UDPAddr, size int) bool {
+	now := time.Now()
+	l.mu.Lock()
+	//先检查来源，被来源限速丢弃的数据报不占用全局配额，否则一个来源就能耗尽 GlobalRate
+	dropped, reason := false, UDP_DROP_SOURCE_RATE
+	src := l.source(udpSourceAddr(addr))
+	if l.PerSourceRate > 0 && !src.bucket.take(now, l.PerSourceRate, l.PerSourceBurst) {
+		dropped = true
+		l.counts.SourceDropped++
+	} else if l.GlobalRate > 0 && !l.global.take(now, l.GlobalRate, l.GlobalBurst) {
+		dropped, reason = true, UDP_DROP_GLOBAL_RATE
+		l.counts.GlobalDropped++
+		if l.PerSourceRate > 0 {
+			src.bucket.tokens++
+		}
+	} else {
+		src.received += size
+	}
+	l.mu.Unlock()
+	if dropped && l.OnDrop != nil {
+		l.OnDrop(addr, reason)
+	}
+	return !dropped
+}
+
+func (l *UDPRateLimiter) allowResponse(addr *net.UDPAddr, size int) bool {
+	if l.AmplificationFactor <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	src := l.source(udpSourceAddr(addr))
+	dropped := !src.verified && src.sent+size > src.received*l.AmplificationFactor
+	if dropped {
+		l.counts.AmplificationDropped++
+	} else {
+		src.sent += size
+	}
+	l.mu.Unlock()
+	if dropped && l.OnDrop != nil {
+		l.OnDrop(addr, UDP_DROP_AMPLIFICATION)
+	}
+	return !dropped
+}
@@ -0,0 +1,121 @@
+// base_socket_udp_limit_test.go
+package gobase
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+//每收到一个数据报回复三倍大小的响应
+type udpAmplifyHandle struct {
+	BaseUDPServerHandle
+	server *BaseUDPServer
+	reads  chan []byte
+}
+
+func (h *udpAmplifyHandle) OnRead(data []byte, addr *net.UDPAddr) {
+	h.reads <- data
+	h.server.WriteToUDP(bytes.Repeat(data, 3), addr)
+}
+
+func startUDPLimitServer(t *testing.T, limiter *UDPRateLimiter) (*BaseUDPServer, *udpAmplifyHandle) {
+	s := &BaseUDPServer{RateLimiter: limiter}
+	h := &udpAmplifyHandle{server: s, reads: make(chan []byte, 100)}
+	s.IBaseUDPStreamHandle = h
+	if err := s.Start("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, h
+}
+
+func Test_UDPRateLimitPerSource(t *testing.T) {
+	drops := make(chan UDPDropReason, 100)
+	limiter := &UDPRateLimiter{PerSourceRate: 0.1, PerSourceBurst: 5, OnDrop: func(addr *net.UDPAddr, reason UDPDropReason) {
+		drops <- reason
+	}}
+	s, h := startUDPLimitServer(t, limiter)
+	conn, err := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 20; i++ {
+		conn.Write([]byte{byte(i)})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for limiter.Stats().SourceDropped < 15 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := limiter.Stats(); stats.SourceDropped != 15 || stats.Sources != 1 || len(h.reads) != 5 {
+		t.Fatalf("unexpected stats %+v, reads %d", stats, len(h.reads))
+	}
+	if reason := <-drops; reason != UDP_DROP_SOURCE_RATE {
+		t.Fatalf("unexpected drop reason %s", reason)
+	}
+}
+
+func Test_UDPRateLimitAmplification(t *testing.T) {
+	limiter := &UDPRateLimiter{AmplificationFactor: 2}
+	s, h := startUDPLimitServer(t, limiter)
+	conn, err := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+	//三倍的响应超过了两倍的限制
+	conn.Write([]byte("probe"))
+	<-h.reads
+	buf := make([]byte, 64)
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("amplified response to unverified source should be dropped")
+	}
+	if stats := limiter.Stats(); stats.AmplificationDropped != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	limiter.Verify(conn.LocalAddr().(*net.UDPAddr))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("probe"))
+	if n, err := conn.Read(buf); err != nil || n != 15 {
+		t.Fatalf("verified source should get full response, n %d, err %v", n, err)
+	}
+}
+
+func Test_UDPRateLimitGlobalAndEvict(t *testing.T) {
+	limiter := &UDPRateLimiter{GlobalRate: 0.1, GlobalBurst: 3, MaxSources: 2, AmplificationFactor: 1}
+	for i := 0; i < 4; i++ {
+		limiter.allow(&net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 1}, 1)
+	}
+	stats := limiter.Stats()
+	//被全局限速丢弃的数据报的来源同样被跟踪
+	if stats.GlobalDropped != 1 || stats.Sources != 2 || stats.Evicted != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	//IPv4 映射地址和 IPv4 地址是同一个来源
+	limiter.Verify(&net.UDPAddr{IP: net.ParseIP("::ffff:10.0.0.2")})
+	if !limiter.allowResponse(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 2)}, 1000) {
+		t.Fatal("verified source should not be limited")
+	}
+}
+
+func Test_UDPRateLimitFloodDoesNotStarveOthers(t *testing.T) {
+	limiter := &UDPRateLimiter{PerSourceRate: 0.1, PerSourceBurst: 5, GlobalRate: 0.1, GlobalBurst: 10}
+	flood, normal := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1}
+	for i := 0; i < 1000; i++ {
+		limiter.allow(flood, 1)
+	}
+	//超过来源限速的数据报不消耗全局配额
+	for i := 0; i < 5; i++ {
+		if !limiter.allow(normal, 1) {
+			t.Fatalf("packet %d from a well-behaved source dropped, stats %+v", i, limiter.Stats())
+		}
+	}
+	if stats := limiter.Stats(); stats.SourceDropped != 995 || stats.GlobalDropped != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,183 @@
+// base_socket_udp_multicast.go
+package gobase
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var ErrUDPNotStarted = errors.New("udp stream not started")
+
+//handle 实现该接口时代替 OnRead 被调用，dst 为数据报的目的地址，组播时即为组地址
+type IBaseUDPDstHandle interface {
+	OnReadWithDst(data []byte, addr *net.UDPAddr, dst net.IP)
+}
+
+func (s *BaseUDPStream) enableDst() {
+	s.dstHandle, _ = s.IBaseUDPStreamHandle.(IBaseUDPDstHandle)
+	if s.dstHandle == nil {
+		return
+	}
+	for _, conn := range s.readerConns {
+		var err error
+		if udpConnIsIPv6(conn) {
+			err = ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagDst|ipv6.FlagInterface, true)
+		} else {
+			err = ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true)
+		}
+		if err != nil {
+			s.log().Log(LOG_LEVEL_WARN, "udp enable destination address failed", "local", conn.LocalAddr(), "err", err)
+		}
+	}
+}
+
+//返回读目的地址用的 oob 缓冲区和解析函数，handle 不需要目的地址时 oob 为 nil
+func (s *BaseUDPStream) dstControl(conn *net.UDPConn) ([]byte, func(oob []byte) net.IP) {
+	if s.dstHandle == nil {
+		return nil, func([]byte) net.IP { return nil }
+	}
+	if udpConnIsIPv6(conn) {
+		return ipv6.NewControlMessage(ipv6.FlagDst | ipv6.FlagInterface), func(oob []byte) net.IP {
+			var cm ipv6.ControlMessage
+			if len(oob) == 0 || cm.Parse(oob) != nil {
+				return nil
+			}
+			return cm.Dst
+		}
+	}
+	return ipv4.NewControlMessage(ipv4.FlagDst | ipv4.FlagInterface), func(oob []byte) net.IP {
+		var cm ipv4.ControlMessage
+		if len(oob) == 0 || cm.Parse(oob) != nil {
+			return nil
+		}
+		return cm.Dst
+	}
+}
+
+//对每个 socket 按组地址的协议族执行 f4 或 f6，IPv6 socket 也可以加入 IPv4 组（双栈）
+func (s *BaseUDPStream) forEachGroupConn(group net.IP, f4 func(p *ipv4.PacketConn) error, f6 func(p *ipv6.PacketConn) error) error {
+	if len(s.readerConns) == 0 {
+		return ErrUDPNotStarted
+	}
+	for _, conn := range s.readerConns {
+		var err error
+		if group.To4() != nil {
+			err = f4(ipv4.NewPacketConn(conn))
+		} else {
+			err = f6(ipv6.NewPacketConn(conn))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//对每个 socket 按 socket 的协议族设置发送选项，双栈 socket 同时尽量设置 IPv4 选项
+func (s *BaseUDPStream) forEachSendConn(f4 func(p *ipv4.PacketConn) error, f6 func(p *ipv6.PacketConn) error) error {
+	if len(s.readerConns) == 0 {
+		return ErrUDPNotStarted
+	}
+	for _, conn := range s.readerConns {
+		if !udpConnIsIPv6(conn) {
+			if err := f4(ipv4.NewPacketConn(conn)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := f6(ipv6.NewPacketConn(conn)); err != nil {
+			return err
+		}
+		if conn.LocalAddr().(*net.UDPAddr).IP.IsUnspecified() {
+			f4(ipv4.NewPacketConn(conn))
+		}
+	}
+	return nil
+}
+
+//ifi 为 nil 时由系统选择网卡
+func (s *BaseUDPStream) JoinGroup(ifi *net.Interface, group net.IP) error {
+	addr := &net.UDPAddr{IP: group}
+	err := s.forEachGroupConn(group,
+		func(p *ipv4.PacketConn) error { return p.JoinGroup(ifi, addr) },
+		func(p *ipv6.PacketConn) error { return p.JoinGroup(ifi, addr) })
+	s.log().Log(LOG_LEVEL_INFO, "udp join group", "group", group, "ifi", ifiName(ifi), "err", err)
+	return err
+}
+
+func (s *BaseUDPStream) LeaveGroup(ifi *net.Interface, group net.IP) error {
+	addr := &net.UDPAddr{IP: group}
+	err := s.forEachGroupConn(group,
+		func(p *ipv4.PacketConn) error { return p.LeaveGroup(ifi, addr) },
+		func(p *ipv6.PacketConn) error { return p.LeaveGroup(ifi, addr) })
+	s.log().Log(LOG_LEVEL_INFO, "udp leave group", "group", group, "ifi", ifiName(ifi), "err", err)
+	return err
+}
+
+//只接收 source 发往 group 的数据报（SSM，RFC 4607）
+func (s *BaseUDPStream) JoinSourceSpecificGroup(ifi *net.Interface, group net.IP, source net.IP) error {
+	groupAddr, sourceAddr := &net.UDPAddr{IP: group}, &net.UDPAddr{IP: source}
+	err := s.forEachGroupConn(group,
+		func(p *ipv4.PacketConn) error { return p.JoinSourceSpecificGroup(ifi, groupAddr, sourceAddr) },
+		func(p *ipv6.PacketConn) error { return p.JoinSourceSpecificGroup(ifi, groupAddr, sourceAddr) })
+	s.log().Log(LOG_LEVEL_INFO, "udp join source specific group", "group", group, "source", source, "ifi", ifiName(ifi), "err", err)
+	return err
+}
+
+func (s *BaseUDPStream) LeaveSourceSpecificGroup(ifi *net.Interface, group net.IP, source net.IP) error {
+	groupAddr, sourceAddr := &net.UDPAddr{IP: group}, &net.UDPAddr{IP: source}
+	err := s.forEachGroupConn(group,
+		func(p *ipv4.PacketConn) error { return p.LeaveSourceSpecificGroup(ifi, groupAddr, sourceAddr) },
+		func(p *ipv6.PacketConn) error { return p.LeaveSourceSpecificGroup(ifi, groupAddr, sourceAddr) })
+	s.log().Log(LOG_LEVEL_INFO, "udp leave source specific group", "group", group, "source", source, "ifi", ifiName(ifi), "err", err)
+	return err
+}
+
+//发送组播使用的网卡
+func (s *BaseUDPStream) SetMulticastInterface(ifi *net.Interface) error {
+	return s.forEachSendConn(
+		func(p *ipv4.PacketConn) error { return p.SetMulticastInterface(ifi) },
+		func(p *ipv6.PacketConn) error { return p.SetMulticastInterface(ifi) })
+}
+
+//IPv4 为 TTL，IPv6 为 hop limit
+func (s *BaseUDPStream) SetMulticastTTL(ttl int) error {
+	return s.forEachSendConn(
+		func(p *ipv4.PacketConn) error { return p.SetMulticastTTL(ttl) },
+		func(p *ipv6.PacketConn) error { return p.SetMulticastHopLimit(ttl) })
+}
+
+//是否收到本机发出的组播
+func (s *BaseUDPStream) SetMulticastLoopback(on bool) error {
+	return s.forEachSendConn(
+		func(p *ipv4.PacketConn) error { return p.SetMulticastLoopback(on) },
+		func(p *ipv6.PacketConn) error { return p.SetMulticastLoopback(on) })
+}
+
+//设置 SO_BROADCAST，Go 默认已为 IPv4 UDP socket 打开
+func (s *BaseUDPStream) SetBroadcast(on bool) error {
+	if len(s.readerConns) == 0 {
+		return ErrUDPNotStarted
+	}
+	for _, conn := range s.readerConns {
+		if err := setUDPBroadcast(conn, on); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//发送到受限广播地址 255.255.255.255:port
+func (s *BaseUDPStream) Broadcast(data []byte, port int) {
+	s.WriteTo(data, &net.UDPAddr{IP: net.IPv4bcast, Port: port})
+}
+
+func ifiName(ifi *net.Interface) string {
+	if ifi == nil {
+		return ""
+	}
+	return ifi.Name
+}
@@ -0,0 +1,94 @@
+// base_socket_udp_multicast_test.go
+package gobase
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type udpDstHandle struct {
+	BaseUDPServerHandle
+	reads chan net.IP
+}
+
+func (h *udpDstHandle) OnRead(data []byte, addr *net.UDPAddr) {
+}
+
+func (h *udpDstHandle) OnReadWithDst(data []byte, addr *net.UDPAddr, dst net.IP) {
+	h.reads <- dst
+}
+
+func loopbackInterface(t *testing.T) *net.Interface {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for i := range ifis {
+		if ifis[i].Flags&net.FlagLoopback != 0 && ifis[i].Flags&net.FlagUp != 0 {
+			return &ifis[i]
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+func Test_UDPMulticast(t *testing.T) {
+	lo := loopbackInterface(t)
+	group := net.IPv4(239, 255, 42, 1)
+
+	h := &udpDstHandle{reads: make(chan net.IP, 4)}
+	receiver := &BaseUDPServer{}
+	receiver.IBaseUDPStreamHandle = h
+	if err := receiver.Start("0.0.0.0", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	if err := receiver.JoinGroup(lo, group); err != nil {
+		t.Skipf("join group on %s failed: %s", lo.Name, err)
+	}
+
+	sender := &BaseUDPClient{}
+	sender.IBaseUDPStreamHandle = &udpDstHandle{reads: make(chan net.IP, 4)}
+	if err := sender.Start("0.0.0.0", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if err := sender.SetMulticastInterface(lo); err != nil {
+		t.Skipf("set multicast interface failed: %s", err)
+	}
+	sender.SetMulticastTTL(1)
+	sender.SetMulticastLoopback(true)
+	sender.WriteTo([]byte("announce"), &net.UDPAddr{IP: group, Port: receiver.Port()})
+
+	select {
+	case dst := <-h.reads:
+		if !dst.Equal(group) {
+			t.Fatalf("unexpected destination %s", dst)
+		}
+	case <-time.After(2 * time.Second):
+		t.Skip("multicast over loopback not routed in this environment")
+	}
+
+	if err := receiver.LeaveGroup(lo, group); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_UDPBroadcastOption(t *testing.T) {
+	s := &BaseUDPClient{}
+	s.IBaseUDPStreamHandle = &udpDstHandle{reads: make(chan net.IP, 4)}
+	if err := s.SetBroadcast(true); err != ErrUDPNotStarted {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := s.Start("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SetBroadcast(false); err != nil {
+		t.Skip(err)
+	}
+	if err := s.SetBroadcast(true); err != nil {
+		t.Fatal(err)
+	}
+}
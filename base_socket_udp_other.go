@@ -0,0 +1,19 @@
+//go:build !unix
+
+// base_socket_udp_other.go
+package gobase
+
+import (
+	"errors"
+	"net"
+)
+
+//没有 MSG_TRUNC 的平台无法检测截断
+func udpTruncated(flags int) bool {
+	return false
+}
+
+//Go 默认已为 IPv4 UDP socket 打开 SO_BROADCAST
+func setUDPBroadcast(conn *net.UDPConn, on bool) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,258 @@
+// base_socket_udp_seal.go
+package gobase
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	DEFAULT_UDP_REPLAY_WINDOW = 1024 //按 64 向上取整
+	UDP_SEAL_HEADER_SIZE      = 14   //magic(1) keyID(1) nonce(12)
+	UDP_SEAL_OVERHEAD         = UDP_SEAL_HEADER_SIZE + 16
+
+	udpSealMagic = 0xa5
+)
+
+type UDPSealCipher int
+
+const (
+	UDP_SEAL_AES_GCM           UDPSealCipher = iota //key 为 16、24 或 32 字节
+	UDP_SEAL_CHACHA20_POLY1305                      //key 为 32 字节
+)
+
+var ErrUDPSealNoKey = errors.New("udp sealer has no send key")
+var ErrUDPSealUnknownKey = errors.New("udp sealer unknown key id")
+
+type UDPSealStats struct {
+	Sealed     uint64
+	Opened     uint64
+	AuthFailed uint64 //认证失败或格式错误
+	Replayed   uint64 //重复或落在重放窗口之外
+	UnknownKey uint64
+}
+
+//预共享密钥按 key ID 区分，接收时接受所有已添加的 key，发送时使用 UseKey 选定的 key，
+//轮换时先在所有端 AddKey，再逐个 UseKey，最后 RemoveKey 旧 key，不需要重启。
+//nonce 为 8 字节随机发送方 ID + 4 字节递增计数，计数用完时换新的发送方 ID，
+//所有发送方共用一个 key 时 ID 冲突的概率可以忽略；重放窗口按 (key ID, 发送方 ID) 维护
+type UDPSealer struct {
+	Cipher       UDPSealCipher
+	ReplayWindow int //0 时为 DEFAULT_UDP_REPLAY_WINDOW
+
+	mu       sync.Mutex
+	keys     map[uint8]cipher.AEAD
+	digests  map[uint8][sha256.Size]byte //key 的摘要，RemoveKey 后保留，用于判断重新添加的是否为同一个 key
+	sendKey  uint8
+	hasSend  bool
+	senderID [8]byte
+	counter  uint32
+	windows  map[udpReplayKey]*udpReplayWindow
+	counts   UDPSealStats
+	initOnce sync.Once
+}
+
+type udpReplayKey struct {
+	keyID    uint8
+	senderID [8]byte
+}
+
+func NewUDPSealer(c UDPSealCipher) *UDPSealer {
+	return &UDPSealer{Cipher: c}
+}
+
+func (s *UDPSealer) init() {
+	s.initOnce.Do(func() {
+		rand.Read(s.senderID[:])
+	})
+}
+
+func (s *UDPSealer) newAEAD(key []byte) (cipher.AEAD, error) {
+	if s.Cipher == UDP_SEAL_CHACHA20_POLY1305 {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//添加或替换 id 对应的 key，第一个添加的 key 同时作为发送 key；
+//重新添加同一个 key（包括 RemoveKey 之后）时保留重放窗口，key 不同时才清空，否则之前的数据报可以被重放
+func (s *UDPSealer) AddKey(id uint8, key []byte) error {
+	aead, err := s.newAEAD(key)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[uint8]cipher.AEAD)
+		s.digests = make(map[uint8][sha256.Size]byte)
+	}
+	s.keys[id] = aead
+	if old, ok := s.digests[id]; !ok || old != digest {
+		s.digests[id] = digest
+		for k := range s.windows {
+			if k.keyID == id {
+				delete(s.windows, k)
+			}
+		}
+	}
+	if !s.hasSend {
+		s.sendKey, s.hasSend = id, true
+	}
+	return nil
+}
+
+//重放窗口保留到 id 被添加为不同的 key
+func (s *UDPSealer) RemoveKey(id uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, id)
+	if s.hasSend && s.sendKey == id {
+		s.hasSend = false
+	}
+}
+
+//之后发送的数据报使用 id 对应的 key
+func (s *UDPSealer) UseKey(id uint8) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[id]; !ok {
+		return fmt.Errorf("%w: %d", ErrUDPSealUnknownKey, id)
+	}
+	s.sendKey, s.hasSend = id, true
+	return nil
+}
+
+func (s *UDPSealer) Stats() UDPSealStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts
+}
+
+func (s *UDPSealer) Seal(plaintext []byte) ([]byte, error) {
+	s.init()
+	s.mu.Lock()
+	if !s.hasSend {
+		s.mu.Unlock()
+		return nil, ErrUDPSealNoKey
+	}
+	id := s.sendKey
+	aead := s.keys[id]
+	if s.counter == math.MaxUint32 {
+		rand.Read(s.senderID[:])
+		s.counter = 0
+	}
+	s.counter++
+	counter := s.counter
+	senderID := s.senderID
+	s.counts.Sealed++
+	s.mu.Unlock()
+
+	buf := make([]byte, UDP_SEAL_HEADER_SIZE, UDP_SEAL_OVERHEAD+len(plaintext))
+	buf[0] = udpSealMagic
+	buf[1] = id
+	copy(buf[2:10], senderID[:])
+	binary.BigEndian.PutUint32(buf[10:], counter)
+	return aead.Seal(buf, buf[2:UDP_SEAL_HEADER_SIZE], plaintext, buf[:2]), nil
+}
+
+//认证失败、未知 key 或重放时返回 false，只有认证通过的数据报才更新重放窗口
+func (s *UDPSealer) Open(data []byte) ([]byte, bool) {
+	if len(data) < UDP_SEAL_OVERHEAD || data[0] != udpSealMagic {
+		s.count(&s.counts.AuthFailed)
+		return nil, false
+	}
+	id := data[1]
+	s.mu.Lock()
+	aead, ok := s.keys[id]
+	if !ok {
+		s.counts.UnknownKey++
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	plaintext, err := aead.Open(nil, data[2:UDP_SEAL_HEADER_SIZE], data[UDP_SEAL_HEADER_SIZE:], data[:2])
+	if err != nil {
+		s.count(&s.counts.AuthFailed)
+		return nil, false
+	}
+
+	key := udpReplayKey{keyID: id}
+	copy(key.senderID[:], data[2:10])
+	counter := uint64(binary.BigEndian.Uint32(data[10:]))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	//key 在 Open 期间被移除
+	if s.keys[id] != aead {
+		s.counts.UnknownKey++
+		return nil, false
+	}
+	if s.windows == nil {
+		s.windows = make(map[udpReplayKey]*udpReplayWindow)
+	}
+	w, ok := s.windows[key]
+	if !ok {
+		w = newUDPReplayWindow(s.ReplayWindow)
+		s.windows[key] = w
+	}
+	if !w.accept(counter) {
+		s.counts.Replayed++
+		return nil, false
+	}
+	s.counts.Opened++
+	return plaintext, true
+}
+
+func (s *UDPSealer) count(n *uint64) {
+	s.mu.Lock()
+	*n++
+	s.mu.Unlock()
+}
+
+//滑动窗口，bits 的第 n%size 位表示计数 n 是否已经收到
+type udpReplayWindow struct {
+	top  uint64
+	bits []uint64
+}
+
+func newUDPReplayWindow(size int) *udpReplayWindow {
+	if size <= 0 {
+		size = DEFAULT_UDP_REPLAY_WINDOW
+	}
+	return &udpReplayWindow{bits: make([]uint64, (size+63)/64)}
+}
+
+func (w *udpReplayWindow) accept(n uint64) bool {
+	size := uint64(len(w.bits) * 64)
+	if n == 0 || n+size <= w.top {
+		return false
+	}
+	if n > w.top {
+		//清掉滑出窗口的位
+		for i := w.top + 1; i <= n && i <= w.top+size; i++ {
+			w.bits[i%size/64] &^= 1 << (i % 64)
+		}
+		w.top = n
+	}
+	bit := uint64(1) << (n % 64)
+	if w.bits[n%size/64]&bit != 0 {
+		return false
+	}
+	w.bits[n%size/64] |= bit
+	return true
+}
@@ -0,0 +1,174 @@
+// base_socket_udp_seal_test.go
+package gobase
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestSealer(t *testing.T, c UDPSealCipher, id uint8, key []byte) *UDPSealer {
+	s := NewUDPSealer(c)
+	if err := s.AddKey(id, key); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func Test_UDPSealEcho(t *testing.T) {
+	for _, c := range []UDPSealCipher{UDP_SEAL_AES_GCM, UDP_SEAL_CHACHA20_POLY1305} {
+		key := bytes.Repeat([]byte{7}, 32)
+		serverSealer := newTestSealer(t, c, 1, key)
+		s, _ := startUDPEchoServer(t, func(s *BaseUDPServer) {
+			s.Sealer = serverSealer
+			s.Fragmentation = true
+		})
+		h := newUDPClientRecordHandle()
+		client := &BaseUDPClient{}
+		client.IBaseUDPStreamHandle = h
+		client.Sealer = newTestSealer(t, c, 1, key)
+		client.Fragmentation = true
+		if err := client.Connect(s.LocalUDPAddr().String()); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		large := bytes.Repeat([]byte("telemetry"), 1000)
+		client.Write(large)
+		select {
+		case data := <-h.reads:
+			if !bytes.Equal(data, large) {
+				t.Fatal("echo corrupted")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("echo not received")
+		}
+
+		//明文和用错误 key 加密的数据报都不会到达 OnRead
+		conn, err := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("plaintext"))
+		forged, _ := newTestSealer(t, c, 1, bytes.Repeat([]byte{8}, 32)).Seal([]byte("forged"))
+		conn.Write(forged)
+		conn.Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for serverSealer.Stats().AuthFailed < 2 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if stats := serverSealer.Stats(); stats.AuthFailed != 2 {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+	}
+}
+
+func Test_UDPSealReplayAndRotation(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16)
+	sender := newTestSealer(t, UDP_SEAL_AES_GCM, 1, key1)
+	receiver := newTestSealer(t, UDP_SEAL_AES_GCM, 1, key1)
+	receiver.ReplayWindow = 64
+
+	first, _ := sender.Seal([]byte("first"))
+	if data, ok := receiver.Open(first); !ok || string(data) != "first" {
+		t.Fatal("open failed")
+	}
+	if _, ok := receiver.Open(first); ok {
+		t.Fatal("replayed datagram accepted")
+	}
+	//乱序但在窗口内的可以接受，落在窗口之外的拒绝
+	var sealed [][]byte
+	for i := 0; i < 100; i++ {
+		data, _ := sender.Seal([]byte{byte(i)})
+		sealed = append(sealed, data)
+	}
+	if _, ok := receiver.Open(sealed[99]); !ok {
+		t.Fatal("open failed")
+	}
+	if _, ok := receiver.Open(sealed[50]); !ok {
+		t.Fatal("out of order datagram inside window rejected")
+	}
+	if _, ok := receiver.Open(sealed[10]); ok {
+		t.Fatal("datagram outside window accepted")
+	}
+
+	//轮换：接收端先添加新 key，发送端再切换，旧 key 移除后不再接受
+	receiver.AddKey(2, key2)
+	sender.AddKey(2, key2)
+	if err := sender.UseKey(2); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := sender.Seal([]byte("rotated"))
+	if data, ok := receiver.Open(rotated); !ok || string(data) != "rotated" {
+		t.Fatal("open with rotated key failed")
+	}
+	receiver.RemoveKey(1)
+	if _, ok := receiver.Open(sealed[98]); ok {
+		t.Fatal("datagram with removed key accepted")
+	}
+	if stats := receiver.Stats(); stats.Opened != 4 || stats.Replayed != 2 || stats.UnknownKey != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func Test_UDPSealSenderIDRollover(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 16)
+	sender := newTestSealer(t, UDP_SEAL_AES_GCM, 1, key)
+	other := newTestSealer(t, UDP_SEAL_AES_GCM, 1, key)
+	receiver := newTestSealer(t, UDP_SEAL_AES_GCM, 1, key)
+
+	//不同发送方使用 8 字节的随机 ID，nonce 不会重复
+	a, _ := sender.Seal([]byte("a"))
+	b, _ := other.Seal([]byte("b"))
+	if bytes.Equal(a[2:UDP_SEAL_HEADER_SIZE], b[2:UDP_SEAL_HEADER_SIZE]) {
+		t.Fatal("two sealers produced the same nonce")
+	}
+	for _, data := range [][]byte{a, b} {
+		if _, ok := receiver.Open(data); !ok {
+			t.Fatal("open failed")
+		}
+	}
+
+	//计数用完后换新的发送方 ID，接收端按新的 ID 重新开始重放窗口
+	sender.mu.Lock()
+	sender.counter = math.MaxUint32 - 1
+	sender.mu.Unlock()
+	last, _ := sender.Seal([]byte("last"))
+	next, _ := sender.Seal([]byte("next"))
+	if bytes.Equal(last[2:10], next[2:10]) {
+		t.Fatal("sender id not changed after counter exhausted")
+	}
+	for _, data := range [][]byte{last, next} {
+		if _, ok := receiver.Open(data); !ok {
+			t.Fatal("open after rollover failed")
+		}
+	}
+	if _, ok := receiver.Open(next); ok {
+		t.Fatal("replayed datagram accepted")
+	}
+}
+
+func Test_UDPSealReAddKeyKeepsReplayWindow(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, 16)
+	sender := newTestSealer(t, UDP_SEAL_AES_GCM, 1, key)
+	receiver := newTestSealer(t, UDP_SEAL_AES_GCM, 1, key)
+	sealed, _ := sender.Seal([]byte("once"))
+	if _, ok := receiver.Open(sealed); !ok {
+		t.Fatal("open failed")
+	}
+	//重新添加同一个 key，或者移除后再添加，之前收到的数据报仍然被当作重放
+	receiver.AddKey(1, key)
+	if _, ok := receiver.Open(sealed); ok {
+		t.Fatal("replay accepted after re-adding the same key")
+	}
+	receiver.RemoveKey(1)
+	receiver.AddKey(1, key)
+	if _, ok := receiver.Open(sealed); ok {
+		t.Fatal("replay accepted after removing and re-adding the same key")
+	}
+	if stats := receiver.Stats(); stats.Opened != 1 || stats.Replayed != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
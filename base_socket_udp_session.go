@@ -0,0 +1,240 @@
+// base_socket_udp_session.go
+package gobase
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrUDPSessionLimit = errors.New("udp session limit reached")
+var errUDPSessionIdleTimeout = fmt.Errorf("udp session idle timeout: %w", os.ErrDeadlineExceeded)
+
+type IBaseUDPSessionHandle interface {
+	IBaseStreamHandle
+	OnStart()
+	OnRead(data []byte)
+}
+
+type BaseUDPSessionHandle struct {
+	BaseIOStreamHandle
+}
+
+func (h *BaseUDPSessionHandle) OnStart() {
+}
+
+//为每个新的远端创建 handle，返回 nil 时丢弃该远端的数据报
+type UDPSessionHandleFactory func(s *BaseUDPSession) IBaseUDPSessionHandle
+
+//BaseUDPServer 上一个远端地址对应的虚拟会话
+type BaseUDPSession struct {
+	IBaseUDPSessionHandle
+	UserData   interface{} //应用层的会话状态，回调都在读 goroutine 中执行
+	server     *BaseUDPServer
+	key        netip.AddrPort
+	addr       *net.UDPAddr
+	lastActive AtomicInt64
+	closed     AtomicInt32
+	closeState streamCloseState
+	ready      chan struct{} //NewSession 和 OnStart 完成后关闭
+}
+
+func (s *BaseUDPSession) isReady() bool {
+	select {
+	case <-s.ready:
+		return s.IBaseUDPSessionHandle != nil
+	default:
+		return false
+	}
+}
+
+func (s *BaseUDPSession) RemoteAddr() net.Addr {
+	return s.addr
+}
+
+func (s *BaseUDPSession) RemoteUDPAddr() *net.UDPAddr {
+	return s.addr
+}
+
+func (s *BaseUDPSession) LocalAddr() net.Addr {
+	return s.server.LocalAddr()
+}
+
+func (s *BaseUDPSession) Write(data []byte) error {
+	if s.closed.Get() != SOCKET_OPEN {
+		return nil
+	}
+	s.server.WriteTo(data, s.addr)
+	return nil
+}
+
+func (s *BaseUDPSession) WriteString(data string) error {
+	return s.Write([]byte(data))
+}
+
+//之后再收到该远端的数据报时创建新的会话
+func (s *BaseUDPSession) Close() {
+	if s.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		reason := s.closeState.reason()
+		s.server.log().Log(LOG_LEVEL_DEBUG, "udp session closed", "remote", s.addr, "reason", reason)
+		s.server.removeSession(s)
+		notifyClose(s.IBaseUDPSessionHandle, reason)
+	}
+}
+
+func (s *BaseUDPSession) CloseWithError(err error) {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.closeState.record("close", err)
+	}
+	s.Close()
+}
+
+//NewSession 不为 nil 时开启会话模式
+func (s *BaseUDPServer) StartByAddr(addr string) error {
+	if s.NewSession != nil {
+		s.sessionsMu.Lock()
+		s.sessions = make(map[netip.AddrPort]*BaseUDPSession)
+		s.sessionsQuit = make(chan struct{})
+		s.sessionsMu.Unlock()
+		s.demux = s.dispatch
+	} else {
+		s.demux = nil
+	}
+	s.limiter = s.RateLimiter
+	if err := s.BaseUDPStream.StartByAddr(addr); err != nil {
+		return err
+	}
+	if s.NewSession != nil {
+		go s.expireLoop(s.sessionsQuit)
+	}
+	return nil
+}
+
+func (s *BaseUDPServer) Start(ip string, port int32) error {
+	return s.StartByAddr(net.JoinHostPort(ip, strconv.FormatInt(int64(port), 10)))
+}
+
+//先关闭所有会话
+func (s *BaseUDPServer) Close() {
+	if s.closed.Get() != SOCKET_OPEN {
+		return
+	}
+	if s.NewSession != nil {
+		s.sessionsMu.Lock()
+		quit := s.sessionsQuit
+		s.sessionsQuit = nil
+		s.sessionsMu.Unlock()
+		if quit != nil {
+			close(quit)
+		}
+		for _, session := range s.Sessions() {
+			session.Close()
+		}
+	}
+	s.BaseUDPStream.Close()
+}
+
+func (s *BaseUDPServer) SessionCount() int {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	return len(s.sessions)
+}
+
+//不包括正在创建的会话
+func (s *BaseUDPServer) Sessions() []*BaseUDPSession {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	sessions := make([]*BaseUDPSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if session.isReady() {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+func (s *BaseUDPServer) dispatch(data []byte, addr *net.UDPAddr) {
+	key := addr.AddrPort()
+	s.sessionsMu.Lock()
+	session := s.sessions[key]
+	if session == nil {
+		if s.MaxSessions > 0 && len(s.sessions) >= s.MaxSessions {
+			s.sessionsMu.Unlock()
+			s.log().Log(LOG_LEVEL_DEBUG, "udp session limit reached, discard datagram", "from", addr, "max", s.MaxSessions)
+			if s.IBaseUDPStreamHandle != nil {
+				s.IBaseUDPStreamHandle.OnException(&StreamError{Kind: ERR_KIND_OVERFLOW, Op: "accept", Err: ErrUDPSessionLimit})
+			}
+			return
+		}
+		//先占位再在锁外调用 NewSession，多个读 goroutine 不会为同一个远端创建多个会话
+		session = &BaseUDPSession{server: s, key: key, addr: &net.UDPAddr{IP: append(net.IP(nil), addr.IP...), Port: addr.Port, Zone: addr.Zone}, ready: make(chan struct{})}
+		session.closed.Set(SOCKET_OPEN)
+		session.lastActive.Set(time.Now().UnixNano())
+		s.sessions[key] = session
+		s.sessionsMu.Unlock()
+
+		handle := s.NewSession(session)
+		if handle == nil {
+			s.removeSession(session)
+			close(session.ready)
+			return
+		}
+		session.IBaseUDPSessionHandle = handle
+		s.log().Log(LOG_LEVEL_DEBUG, "udp session started", "remote", addr)
+		handle.OnStart()
+		close(session.ready)
+	} else {
+		s.sessionsMu.Unlock()
+		//其他读 goroutine 正在创建该会话时等待 OnStart 完成
+		<-session.ready
+		if session.IBaseUDPSessionHandle == nil {
+			return
+		}
+		session.lastActive.Set(time.Now().UnixNano())
+	}
+	if session.closed.Get() == SOCKET_OPEN {
+		session.IBaseUDPSessionHandle.OnRead(data)
+	}
+}
+
+func (s *BaseUDPServer) removeSession(session *BaseUDPSession) {
+	s.sessionsMu.Lock()
+	if s.sessions[session.key] == session {
+		delete(s.sessions, session.key)
+	}
+	s.sessionsMu.Unlock()
+}
+
+func (s *BaseUDPServer) idleTimeOut() time.Duration {
+	if s.SessionIdleTimeOut <= 0 {
+		return DEFAULT_DEADLINE * time.Second
+	}
+	return s.SessionIdleTimeOut * time.Second
+}
+
+func (s *BaseUDPServer) expireLoop(quit chan struct{}) {
+	timeout := s.idleTimeOut()
+	interval := timeout / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, session := range s.Sessions() {
+				if now.Sub(time.Unix(0, session.lastActive.Get())) >= timeout {
+					session.closeState.record("read", errUDPSessionIdleTimeout)
+					session.Close()
+				}
+			}
+		case <-quit:
+			return
+		}
+	}
+}
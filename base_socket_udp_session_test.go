@@ -0,0 +1,175 @@
+// base_socket_udp_session_test.go
+package gobase
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//回复 "<会话内的消息序号>:<数据>"
+type udpCounterSessionHandle struct {
+	BaseUDPSessionHandle
+	session *BaseUDPSession
+	closed  chan CloseReason
+}
+
+func (h *udpCounterSessionHandle) OnStart() {
+	h.session.UserData = 0
+}
+
+func (h *udpCounterSessionHandle) OnRead(data []byte) {
+	n := h.session.UserData.(int) + 1
+	h.session.UserData = n
+	h.session.WriteString(strconv.Itoa(n) + ":" + string(data))
+}
+
+func (h *udpCounterSessionHandle) OnCloseWithReason(reason CloseReason) {
+	h.closed <- reason
+}
+
+type udpSessionServerHandle struct {
+	BaseUDPServerHandle
+	exceptions chan error
+}
+
+func (h *udpSessionServerHandle) OnRead(data []byte, addr *net.UDPAddr) {
+}
+
+func (h *udpSessionServerHandle) OnException(err error) {
+	h.exceptions <- err
+}
+
+func startUDPSessionServer(t *testing.T, config func(s *BaseUDPServer)) (*BaseUDPServer, *udpSessionServerHandle, chan CloseReason) {
+	closed := make(chan CloseReason, 16)
+	h := &udpSessionServerHandle{exceptions: make(chan error, 16)}
+	s := &BaseUDPServer{}
+	s.IBaseUDPStreamHandle = h
+	s.NewSession = func(session *BaseUDPSession) IBaseUDPSessionHandle {
+		return &udpCounterSessionHandle{session: session, closed: closed}
+	}
+	config(s)
+	if err := s.Start("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, h, closed
+}
+
+func udpRequest(t *testing.T, conn *net.UDPConn, msg string) string {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(msg))
+	buf := make([]byte, 128)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func Test_UDPSessionPerPeer(t *testing.T) {
+	s, _, _ := startUDPSessionServer(t, func(s *BaseUDPServer) {})
+	a, _ := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+	defer a.Close()
+	b, _ := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+	defer b.Close()
+
+	if resp := udpRequest(t, a, "x"); resp != "1:x" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if resp := udpRequest(t, a, "y"); resp != "2:y" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if resp := udpRequest(t, b, "z"); resp != "1:z" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if n := s.SessionCount(); n != 2 {
+		t.Fatalf("unexpected session count %d", n)
+	}
+}
+
+func Test_UDPSessionLimit(t *testing.T) {
+	s, h, _ := startUDPSessionServer(t, func(s *BaseUDPServer) {
+		s.MaxSessions = 1
+	})
+	a, _ := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+	defer a.Close()
+	b, _ := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+	defer b.Close()
+
+	udpRequest(t, a, "x")
+	b.Write([]byte("y"))
+	select {
+	case err := <-h.exceptions:
+		if !errors.Is(err, ErrUDPSessionLimit) || !IsOverflowError(err) {
+			t.Fatalf("unexpected exception %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session limit not reported")
+	}
+	if n := s.SessionCount(); n != 1 {
+		t.Fatalf("unexpected session count %d", n)
+	}
+}
+
+func Test_UDPSessionIdleExpiry(t *testing.T) {
+	s, _, closed := startUDPSessionServer(t, func(s *BaseUDPServer) {
+		s.SessionIdleTimeOut = 1
+	})
+	a, _ := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+	defer a.Close()
+
+	udpRequest(t, a, "x")
+	select {
+	case reason := <-closed:
+		if reason.Kind != ERR_KIND_TIMEOUT {
+			t.Fatalf("unexpected close reason %s", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle session not expired")
+	}
+	if n := s.SessionCount(); n != 0 {
+		t.Fatalf("unexpected session count %d", n)
+	}
+	//新的数据报创建新的会话
+	if resp := udpRequest(t, a, "y"); resp != "1:y" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+}
+
+func Test_UDPSessionConcurrentDispatch(t *testing.T) {
+	var created AtomicInt32
+	var reads AtomicInt32
+	s := &BaseUDPServer{sessions: make(map[netip.AddrPort]*BaseUDPSession)}
+	s.NewSession = func(session *BaseUDPSession) IBaseUDPSessionHandle {
+		created.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return &udpCountingReadHandle{reads: &reads}
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.dispatch([]byte("x"), addr)
+		}()
+	}
+	wg.Wait()
+	if created.Get() != 1 || s.SessionCount() != 1 || reads.Get() != 8 {
+		t.Fatalf("created %d sessions, count %d, reads %d", created.Get(), s.SessionCount(), reads.Get())
+	}
+}
+
+type udpCountingReadHandle struct {
+	BaseUDPSessionHandle
+	reads *AtomicInt32
+}
+
+func (h *udpCountingReadHandle) OnRead(data []byte) {
+	h.reads.Add(1)
+}
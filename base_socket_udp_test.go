@@ -0,0 +1,87 @@
+// base_socket_udp_test.go
+package gobase
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_ResolveUDPListenAddr(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		ip      string
+		zone    string
+	}{
+		{"127.0.0.1:0", "udp4", "127.0.0.1", ""},
+		{"0.0.0.0:53", "udp4", "0.0.0.0", ""},
+		{"[::1]:8000", "udp6", "::1", ""},
+		{"[::]:8000", "udp", "::", ""},
+		{":8000", "udp", "<nil>", ""},
+		{"[::ffff:10.0.0.1]:8000", "udp4", "10.0.0.1", ""},
+		{"[fe80::1%eth0]:8000", "udp6", "fe80::1", "eth0"},
+	}
+	for _, c := range cases {
+		network, udpAddr, err := resolveUDPListenAddr(c.addr)
+		if err != nil {
+			t.Fatalf("%s: %s", c.addr, err)
+		}
+		if network != c.network || udpAddr.IP.String() != c.ip || udpAddr.Zone != c.zone {
+			t.Fatalf("%s: got %s %s zone %q", c.addr, network, udpAddr.IP, udpAddr.Zone)
+		}
+	}
+	for _, addr := range []string{"::1:8000", "127.0.0.1", "127.0.0.1:70000"} {
+		if _, _, err := resolveUDPListenAddr(addr); err == nil {
+			t.Fatalf("%s: expected error", addr)
+		}
+	}
+}
+
+func udpEchoRoundTrip(t *testing.T, network string, server *net.UDPAddr, msg string) {
+	conn, err := net.DialUDP(network, nil, server)
+	if err != nil {
+		t.Skipf("%s not available: %s", network, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(msg))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != msg {
+		t.Fatalf("unexpected echo %q, %v", buf[:n], err)
+	}
+}
+
+func Test_UDPIPv6(t *testing.T) {
+	s := &BaseUDPServer{}
+	s.IBaseUDPStreamHandle = &udpEchoHandle{server: s, exceptions: make(chan error, 16)}
+	if err := s.StartByAddr("[::1]:0"); err != nil {
+		t.Skipf("ipv6 not available: %s", err)
+	}
+	defer s.Close()
+	if s.Port() == 0 || s.LocalUDPAddr().IP.String() != "::1" {
+		t.Fatalf("unexpected bound address %s", s.LocalUDPAddr())
+	}
+	udpEchoRoundTrip(t, "udp6", s.LocalUDPAddr(), "hello v6")
+}
+
+func Test_UDPDualStack(t *testing.T) {
+	s := &BaseUDPServer{}
+	s.IBaseUDPStreamHandle = &udpEchoHandle{server: s, exceptions: make(chan error, 16)}
+	if err := s.Start("", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Port() == 0 {
+		t.Fatal("bound port not reported")
+	}
+	port := strconv.Itoa(s.Port())
+	v4, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:"+port)
+	udpEchoRoundTrip(t, "udp4", v4, "hello v4")
+	if s.LocalUDPAddr().IP.To4() == nil {
+		v6, _ := net.ResolveUDPAddr("udp6", "[::1]:"+port)
+		udpEchoRoundTrip(t, "udp6", v6, "hello v6")
+	}
+}
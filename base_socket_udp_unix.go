@@ -0,0 +1,32 @@
+//go:build unix
+
+// base_socket_udp_unix.go
+package gobase
+
+import (
+	"net"
+	"syscall"
+)
+
+//recvmsg 返回的 flags 中带 MSG_TRUNC 表示数据报比缓冲区大
+func udpTruncated(flags int) bool {
+	return flags&syscall.MSG_TRUNC != 0
+}
+
+func setUDPBroadcast(conn *net.UDPConn, on bool) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	v := 0
+	if on {
+		v = 1
+	}
+	var serr error
+	if err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, v)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,141 @@
+// base_socket_unix_cred.go
+package gobase
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+const UNIX_MAX_FDS = 16 //一次读取最多接收的文件描述符数
+
+var ErrUnixPeerRejected = errors.New("unix peer rejected")
+var ErrUnixRightsTruncated = errors.New("unix rights truncated")
+
+//SO_PEERCRED，连接建立时对端进程的凭证
+type UnixCredentials struct {
+	PID int
+	UID int
+	GID int
+}
+
+func (c *UnixCredentials) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d", c.PID, c.UID, c.GID)
+}
+
+//handle 实现该接口时代替 OnRead，files 为随数据一起收到的文件，由 handle 负责关闭
+type IBaseUnixFDsHandle interface {
+	OnReadWithFDs(data []byte, files []*os.File)
+}
+
+type unixWrite struct {
+	data   []byte
+	rights *unixRights
+}
+
+//对端进程的凭证，目前只支持 Linux
+func (c *BaseUnixStream) PeerCredentials() (*UnixCredentials, error) {
+	conn, ok := c.Conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return peerCredentials(conn)
+}
+
+func (c *BaseUnixStream) fdsHandle() IBaseUnixFDsHandle {
+	h, _ := c.IBaseUnixStreamHandle.(IBaseUnixFDsHandle)
+	return h
+}
+
+//files 在调用时被复制，返回后调用方可以关闭；data 不能为空，流式 socket 上文件随 data 的第一个字节到达
+func (c *BaseUnixStream) WriteWithFDs(data []byte, files ...*os.File) error {
+	if len(data) == 0 {
+		return errors.New("unix write with fds requires data")
+	}
+	if c.closed.Get() != SOCKET_OPEN {
+		return nil
+	}
+	rights, err := newUnixRights(files)
+	if err != nil {
+		return err
+	}
+	if len(c.writeChan) == c.writeChanSize {
+		rights.release()
+		err := NewStreamError("write", ErrWriteOverflow)
+		c.log().Log(LOG_LEVEL_WARN, "unix stream write chan overflow, discard data", "local", c.Conn.LocalAddr(), "size", len(data))
+		return err
+	}
+	c.writeChan <- unixWrite{data: data, rights: rights}
+	return nil
+}
+
+//先把缓冲中的数据发出去，保证顺序
+func (c *BaseUnixStream) writeWithRights(w unixWrite) {
+	defer w.rights.release()
+	if c.writer.Buffered() > 0 {
+		if err := c.writer.Flush(); err != nil {
+			err = c.onError("write", err)
+			if c.IBaseUnixStreamHandle != nil {
+				c.IBaseUnixStreamHandle.OnException(err)
+			}
+			return
+		}
+	}
+	n, _, err := c.Conn.(*net.UnixConn).WriteMsgUnix(w.data, w.rights.oob, nil)
+	if err != nil {
+		err = c.onError("write", err)
+		if c.IBaseUnixStreamHandle != nil {
+			c.IBaseUnixStreamHandle.OnException(err)
+		}
+		return
+	}
+	if n < len(w.data) {
+		c.write(w.data[n:])
+	}
+}
+
+//关闭后没有发出去的文件描述符
+func (c *BaseUnixStream) releasePendingRights() {
+	for {
+		select {
+		case w := <-c.writeChan:
+			if w.rights != nil {
+				w.rights.release()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func closeUnixFiles(files []*os.File, _ error) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+//不支持对端凭证的平台上设置了 Authorize 时不启动，否则所有连接都会被拒绝
+func (s *BaseUnixServer) checkAuthorize() error {
+	if s.Authorize != nil && !peerCredentialsSupported {
+		return fmt.Errorf("unix server authorize: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+//读到 SO_PEERCRED 后调用 Authorize，拒绝时关闭连接
+func (s *BaseUnixServer) authorize(conn *net.UnixConn) bool {
+	cred, err := peerCredentials(conn)
+	if err == nil {
+		err = s.Authorize(conn, cred)
+	}
+	if err == nil {
+		return true
+	}
+	s.log().Log(LOG_LEVEL_WARN, "unix server rejected peer", "addr", s.Listener.Addr(), "cred", cred, "err", err)
+	conn.Close()
+	if s.IBaseUnixServerHandle != nil {
+		s.IBaseUnixServerHandle.OnException(&StreamError{Kind: ERR_KIND_PROTOCOL, Op: "accept", Err: fmt.Errorf("%w: %w", ErrUnixPeerRejected, err)})
+	}
+	return false
+}
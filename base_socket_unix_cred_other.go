@@ -0,0 +1,15 @@
+//go:build !linux
+
+// base_socket_unix_cred_other.go
+package gobase
+
+import (
+	"errors"
+	"net"
+)
+
+const peerCredentialsSupported = false
+
+func peerCredentials(conn *net.UnixConn) (*UnixCredentials, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,172 @@
+// base_socket_unix_cred_test.go
+package gobase
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type unixFDsRecord struct {
+	data  []byte
+	files []*os.File
+}
+
+type unixFDsSessionHandle struct {
+	BaseUnixSessionHandle
+	reads chan unixFDsRecord
+}
+
+func (h *unixFDsSessionHandle) OnReadWithFDs(data []byte, files []*os.File) {
+	h.reads <- unixFDsRecord{data: data, files: files}
+}
+
+type unixCredServerHandle struct {
+	BaseUnixServerHandle
+	sessions   chan *BaseUnixSession
+	exceptions chan error
+}
+
+func (h *unixCredServerHandle) OnAccept(conn net.Conn) {
+	session := &BaseUnixSession{}
+	session.Conn = conn
+	session.IBaseUnixStreamHandle = &unixFDsSessionHandle{reads: make(chan unixFDsRecord, 4)}
+	session.Start()
+	h.sessions <- session
+}
+
+func (h *unixCredServerHandle) OnException(err error) {
+	h.exceptions <- err
+}
+
+func startUnixCredServer(t *testing.T, authorize func(conn *net.UnixConn, cred *UnixCredentials) error) (*BaseUnixServer, *unixCredServerHandle, string) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on linux")
+	}
+	path := filepath.Join(t.TempDir(), "cred.sock")
+	h := &unixCredServerHandle{sessions: make(chan *BaseUnixSession, 1), exceptions: make(chan error, 4)}
+	s := &BaseUnixServer{Authorize: authorize, IBaseUnixServerHandle: h}
+	if err := s.StartByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, h, path
+}
+
+func connectUnixClient(t *testing.T, path string) *BaseUnixClient {
+	c := &BaseUnixClient{}
+	c.IBaseUnixStreamHandle = &BaseUnixClientHandle{}
+	if err := c.ConnectByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func Test_UnixPeerCredentials(t *testing.T) {
+	var authorized *UnixCredentials
+	_, h, path := startUnixCredServer(t, func(conn *net.UnixConn, cred *UnixCredentials) error {
+		authorized = cred
+		return nil
+	})
+	c := connectUnixClient(t, path)
+	session := <-h.sessions
+	defer session.Close()
+	if authorized == nil || authorized.PID != os.Getpid() || authorized.UID != os.Getuid() {
+		t.Fatalf("unexpected authorized credentials %v", authorized)
+	}
+	for _, stream := range []*BaseUnixStream{&c.BaseUnixStream, &session.BaseUnixStream} {
+		cred, err := stream.PeerCredentials()
+		if err != nil || cred.PID != os.Getpid() || cred.GID != os.Getgid() {
+			t.Fatalf("unexpected credentials %v, err %v", cred, err)
+		}
+	}
+}
+
+func Test_UnixAuthorizeReject(t *testing.T) {
+	_, h, path := startUnixCredServer(t, func(conn *net.UnixConn, cred *UnixCredentials) error {
+		return errors.New("uid not allowed")
+	})
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case err := <-h.exceptions:
+		if !errors.Is(err, ErrUnixPeerRejected) {
+			t.Fatalf("unexpected exception %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rejection not reported")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("rejected connection should be closed, err %v", err)
+	}
+	if len(h.sessions) != 0 {
+		t.Fatal("rejected connection should not reach OnAccept")
+	}
+}
+
+func Test_UnixAuthorizeUnsupported(t *testing.T) {
+	if peerCredentialsSupported {
+		t.Skip("peer credentials supported on this platform")
+	}
+	s := &BaseUnixServer{Authorize: func(conn *net.UnixConn, cred *UnixCredentials) error {
+		return nil
+	}}
+	if err := s.StartByAddr(filepath.Join(t.TempDir(), "cred.sock")); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expect ErrUnsupported, got %v", err)
+	}
+}
+
+func Test_UnixWriteWithFDs(t *testing.T) {
+	_, h, path := startUnixCredServer(t, nil)
+	c := connectUnixClient(t, path)
+	session := <-h.sessions
+	defer session.Close()
+
+	f, err := os.CreateTemp(t.TempDir(), "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("shared content")
+	c.Write([]byte("before"))
+	if err := c.WriteWithFDs([]byte("file"), f); err != nil {
+		t.Fatal(err)
+	}
+	//发送时已经复制了文件描述符
+	f.Close()
+
+	reads := session.IBaseUnixStreamHandle.(*unixFDsSessionHandle).reads
+	var data []byte
+	var files []*os.File
+	deadline := time.After(5 * time.Second)
+	for len(files) == 0 {
+		select {
+		case r := <-reads:
+			data = append(data, r.data...)
+			files = append(files, r.files...)
+		case <-deadline:
+			t.Fatal("file not received")
+		}
+	}
+	if string(data[:6]) != "before" {
+		t.Fatalf("unexpected data order %q", data)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expect 1 file, got %d", len(files))
+	}
+	defer files[0].Close()
+	content := make([]byte, 64)
+	n, _ := files[0].ReadAt(content, 0)
+	if string(content[:n]) != "shared content" {
+		t.Fatalf("unexpected file content %q", content[:n])
+	}
+}
@@ -0,0 +1,130 @@
+// base_socket_unix_file.go
+package gobase
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrUnixSocketInUse = errors.New("unix socket address in use")
+var ErrUnixAbstractUnsupported = errors.New("unix abstract namespace is only supported on linux")
+
+//BaseUnixServer 和 BaseUnixgramServer 的 socket 文件选项，地址以 "@" 开头时为 Linux 抽象命名空间，
+//没有文件，这些选项都被忽略。
+//FileMode、Owner、Group 在监听之后才设置，在这之前 socket 文件的权限由 umask 决定，umask 允许的用户可以连接；
+//需要严格限制时把 socket 放在只有允许的用户能访问的目录中（例如 0750 的目录），不要依赖这些选项
+type UnixSocketOptions struct {
+	RemoveStale   bool        //启动时 socket 文件已存在且确认没有进程在监听时删除，不是 socket 的文件不会删除
+	RemoveOnClose bool        //Close 时删除 socket 文件，"unix" 监听时 Go 默认也会删除自己创建的文件
+	FileMode      os.FileMode //不为 0 时设置 socket 文件的权限，例如 0660
+	Owner         string      //用户名或 uid，为空时不修改
+	Group         string      //组名或 gid，为空时不修改
+}
+
+func isAbstractUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, "@")
+}
+
+//监听前调用
+func (o *UnixSocketOptions) prepare(network string, addr string) error {
+	if isAbstractUnixAddr(addr) {
+		if runtime.GOOS != "linux" && runtime.GOOS != "android" {
+			return ErrUnixAbstractUnsupported
+		}
+		return nil
+	}
+	if !o.RemoveStale {
+		return nil
+	}
+	fi, err := os.Lstat(addr)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", addr)
+	}
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%w: %s", ErrUnixSocketInUse, addr)
+	}
+	//只有确认没有进程在监听时才删除，其他错误（例如权限）原样返回
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+//监听成功后调用，bind 和 chmod 之间有一个由 umask 决定权限的窗口，见 UnixSocketOptions
+func (o *UnixSocketOptions) apply(addr string) error {
+	if isAbstractUnixAddr(addr) {
+		return nil
+	}
+	if o.FileMode != 0 {
+		if err := os.Chmod(addr, o.FileMode); err != nil {
+			return err
+		}
+	}
+	if o.Owner == "" && o.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if o.Owner != "" {
+		id, err := lookupUnixID(o.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		uid = id
+	}
+	if o.Group != "" {
+		id, err := lookupUnixID(o.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		gid = id
+	}
+	return os.Chown(addr, uid, gid)
+}
+
+//name 为数字时直接作为 id
+func lookupUnixID(name string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	s, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(s)
+}
+
+func (o *UnixSocketOptions) cleanup(addr string) {
+	if o.RemoveOnClose && addr != "" && !isAbstractUnixAddr(addr) {
+		os.Remove(addr)
+	}
+}
@@ -0,0 +1,111 @@
+// base_socket_unix_file_test.go
+package gobase
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+//模拟进程崩溃后遗留的 socket 文件
+func staleUnixSocket(t *testing.T, path string) {
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetUnlinkOnClose(false)
+	ln.Close()
+}
+
+func Test_UnixServerRemoveStale(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket file mode not supported")
+	}
+	path := filepath.Join(t.TempDir(), "server.sock")
+	staleUnixSocket(t, path)
+
+	s := &BaseUnixServer{}
+	if err := s.StartByAddr(path); err == nil {
+		s.Close()
+		t.Fatal("stale socket should fail without RemoveStale")
+	}
+	s = &BaseUnixServer{UnixSocketOptions: UnixSocketOptions{RemoveStale: true, RemoveOnClose: true, FileMode: 0600}}
+	if err := s.StartByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected socket file mode %v, err %v", fi, err)
+	}
+
+	//正在监听的 socket 不会被删除
+	other := &BaseUnixServer{UnixSocketOptions: UnixSocketOptions{RemoveStale: true}}
+	if err := other.StartByAddr(path); !errors.Is(err, ErrUnixSocketInUse) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	s.Close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("socket file should be removed on close")
+	}
+}
+
+func Test_UnixServerOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket file owner not supported")
+	}
+	path := filepath.Join(t.TempDir(), "server.sock")
+	gid := strconv.Itoa(os.Getgid())
+	s := &BaseUnixServer{UnixSocketOptions: UnixSocketOptions{Owner: strconv.Itoa(os.Getuid()), Group: gid}}
+	if err := s.StartByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = &BaseUnixServer{UnixSocketOptions: UnixSocketOptions{Group: "no-such-group-for-gobase"}}
+	if err := s.StartByAddr(path); err == nil {
+		s.Close()
+		t.Fatal("unknown group should fail")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("socket file should be removed when options fail")
+	}
+}
+
+func Test_UnixgramRemoveOnClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram not supported")
+	}
+	path := filepath.Join(t.TempDir(), "gram.sock")
+	s := &BaseUnixgramServer{UnixSocketOptions: UnixSocketOptions{RemoveOnClose: true}}
+	if err := s.StartByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("socket file should be removed on close")
+	}
+}
+
+func Test_UnixAbstractNamespace(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract namespace only on linux")
+	}
+	addr := "@gobase-test-" + strconv.Itoa(os.Getpid())
+	s := &BaseUnixServer{UnixSocketOptions: UnixSocketOptions{RemoveStale: true, RemoveOnClose: true, FileMode: 0600}}
+	if err := s.StartByAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := os.Stat(addr); err == nil {
+		t.Fatal("abstract address should not create a file")
+	}
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
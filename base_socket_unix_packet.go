@@ -0,0 +1,354 @@
+// base_socket_unix_packet.go
+package gobase
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const UNIX_PACKET_MAX_SIZE = 64 * 1024
+
+func (c *BaseUnixStream) packetSize() int {
+	if c.ReadBufferSize > 0 {
+		return c.ReadBufferSize
+	}
+	return UNIX_PACKET_MAX_SIZE
+}
+
+//unixpacket 时每次读到一个完整的消息，空消息以空的 data 回调，被截断的消息丢弃后以 ERR_KIND_OVERFLOW 回调 OnException，读循环继续；
+//handle 实现了 IBaseUnixFDsHandle 时同时接收 SCM_RIGHTS
+func (c *BaseUnixStream) packetReadLoop() {
+	conn := c.Conn.(*net.UnixConn)
+	size := int(SOCKET_READ_BUFFER_SIZE)
+	if c.packet {
+		size = c.packetSize()
+	}
+	p := make([]byte, size)
+	fdsHandle := c.fdsHandle()
+	var oob []byte
+	if fdsHandle != nil {
+		oob = make([]byte, unixRightsSpace())
+	}
+	for {
+		n, oobn, flags, _, err := conn.ReadMsgUnix(p, oob)
+		//net 把 unixpacket 读到 0 字节当作 io.EOF，但空消息也是 0 字节，对端没有关闭时按空消息处理
+		if err == io.EOF && c.packet && !unixPacketPeerClosed(conn) {
+			err = nil
+		}
+		if err != nil {
+			err = c.onError("read", err)
+			if c.IBaseUnixStreamHandle != nil {
+				c.IBaseUnixStreamHandle.OnException(err)
+			}
+			break
+		}
+		//对端关闭时读到 0 字节
+		if n == 0 && oobn == 0 && flags == 0 && !c.packet {
+			err = c.onError("read", io.EOF)
+			if c.IBaseUnixStreamHandle != nil {
+				c.IBaseUnixStreamHandle.OnException(err)
+			}
+			break
+		}
+		c.Conn.SetDeadline(time.Now().Add(c.deadLine * time.Second))
+		if c.packet && udpTruncated(flags) {
+			c.log().Log(LOG_LEVEL_WARN, "unix packet truncated", "local", c.Conn.LocalAddr(), "buffer", len(p))
+			closeUnixFiles(parseUnixRights(oob[:oobn], flags))
+			if c.IBaseUnixStreamHandle != nil {
+				c.IBaseUnixStreamHandle.OnException(&StreamError{
+					Kind: ERR_KIND_OVERFLOW,
+					Op:   "read",
+					Err:  fmt.Errorf("%w, buffer size %d", ErrDatagramTruncated, len(p)),
+				})
+			}
+			continue
+		}
+		data := append([]byte(nil), p[:n]...)
+		if fdsHandle != nil {
+			files, err := parseUnixRights(oob[:oobn], flags)
+			if err != nil {
+				c.log().Log(LOG_LEVEL_WARN, "unix read rights failed", "local", c.Conn.LocalAddr(), "err", err)
+				c.IBaseUnixStreamHandle.OnException(&StreamError{Kind: ERR_KIND_PROTOCOL, Op: "read", Err: err})
+			}
+			fdsHandle.OnReadWithFDs(data, files)
+		} else if c.IBaseUnixStreamHandle != nil {
+			c.IBaseUnixStreamHandle.OnRead(data)
+		}
+	}
+}
+
+//unixpacket 的写是原子的，不经过 bufio，否则多个消息会被合并
+func (c *BaseUnixStream) writePacket(data []byte) {
+	if _, err := c.Conn.Write(data); err != nil {
+		err = c.onError("write", err)
+		if c.IBaseUnixStreamHandle != nil {
+			c.IBaseUnixStreamHandle.OnException(err)
+		}
+		return
+	}
+	c.Conn.SetDeadline(time.Now().Add(c.deadLine * time.Second))
+}
+
+/// Unix Datagram
+type IBaseUnixgramStreamHandle interface {
+	IBaseStreamHandle
+	OnStart()
+	OnRead(data []byte, addr *net.UnixAddr) //addr 为对端绑定的地址，对端没有绑定时为 nil
+}
+
+type BaseUnixgramHandle struct {
+}
+
+func (h *BaseUnixgramHandle) OnStart() {
+}
+
+func (h *BaseUnixgramHandle) OnException(err error) {
+}
+
+func (h *BaseUnixgramHandle) OnClose() {
+}
+
+type unixgramMsg struct {
+	data     []byte
+	destAddr *net.UnixAddr
+}
+
+type BaseUnixgramStream struct {
+	net.Conn
+	IBaseUnixgramStreamHandle
+	Logger                Logger
+	ReadBufferSize        int //单个数据报的最大长度，超过时丢弃并 OnException，0 时为 UNIX_PACKET_MAX_SIZE
+	closed                AtomicInt32
+	writeChan             chan *unixgramMsg
+	writeChanSize         int
+	writtingLoopCloseChan chan struct{}
+	wg                    *sync.WaitGroup
+	closeState            streamCloseState
+}
+
+type BaseUnixgramServer struct {
+	BaseUnixgramStream
+	UnixSocketOptions
+}
+
+type BaseUnixgramClient struct {
+	BaseUnixgramStream
+	RemoteAddress string
+}
+
+func (s *BaseUnixgramStream) log() Logger {
+	return loggerOrNop(s.Logger)
+}
+
+func (s *BaseUnixgramStream) LocalUnixAddr() *net.UnixAddr {
+	addr, _ := s.Conn.LocalAddr().(*net.UnixAddr)
+	return addr
+}
+
+func (s *BaseUnixgramStream) start(conn *net.UnixConn) {
+	if s.wg != nil {
+		s.wg.Wait()
+	} else {
+		s.wg = &sync.WaitGroup{}
+	}
+	s.Conn = conn
+	s.writeChanSize = 3000
+	s.writeChan = make(chan *unixgramMsg, s.writeChanSize)
+	s.writtingLoopCloseChan = make(chan struct{})
+	s.closeState.reset()
+	s.closed.Set(SOCKET_OPEN)
+	if s.IBaseUnixgramStreamHandle != nil {
+		s.IBaseUnixgramStreamHandle.OnStart()
+	}
+
+	s.wg.Add(2)
+	go s.readLoop()
+	go s.writeLoop()
+}
+
+func (s *BaseUnixgramStream) readLoop() {
+	defer s.wg.Done()
+	conn := s.Conn.(*net.UnixConn)
+	size := s.ReadBufferSize
+	if size <= 0 {
+		size = UNIX_PACKET_MAX_SIZE
+	}
+	p := make([]byte, size)
+	for {
+		n, _, flags, addr, err := conn.ReadMsgUnix(p, nil)
+		if err != nil {
+			if s.closed.Get() == SOCKET_OPEN {
+				se := s.closeState.record("read", err)
+				s.log().Log(LOG_LEVEL_DEBUG, "unixgram read failed", "local", s.Conn.LocalAddr(), "kind", se.Kind, "err", err)
+			}
+			if s.IBaseUnixgramStreamHandle != nil {
+				s.IBaseUnixgramStreamHandle.OnException(NewStreamError("read", err))
+			}
+			break
+		}
+		if udpTruncated(flags) {
+			s.log().Log(LOG_LEVEL_WARN, "unixgram datagram truncated", "from", addr, "buffer", len(p))
+			if s.IBaseUnixgramStreamHandle != nil {
+				s.IBaseUnixgramStreamHandle.OnException(&StreamError{
+					Kind: ERR_KIND_OVERFLOW,
+					Op:   "read",
+					Err:  fmt.Errorf("%w, from %s, buffer size %d", ErrDatagramTruncated, addr, len(p)),
+				})
+			}
+			continue
+		}
+		if s.IBaseUnixgramStreamHandle != nil {
+			s.IBaseUnixgramStreamHandle.OnRead(append([]byte(nil), p[:n]...), addr)
+		}
+	}
+}
+
+func (s *BaseUnixgramStream) writeLoop() {
+	defer s.wg.Done()
+	conn := s.Conn.(*net.UnixConn)
+	for {
+		select {
+		case msg := <-s.writeChan:
+			var err error
+			if msg.destAddr == nil {
+				_, err = conn.Write(msg.data)
+			} else {
+				_, err = conn.WriteToUnix(msg.data, msg.destAddr)
+			}
+			if err != nil {
+				s.log().Log(LOG_LEVEL_WARN, "unixgram write failed", "dest", msg.destAddr, "size", len(msg.data), "err", err)
+			}
+		case <-s.writtingLoopCloseChan:
+			return
+		}
+	}
+}
+
+func (s *BaseUnixgramStream) write(msg *unixgramMsg) error {
+	if s.closed.Get() != SOCKET_OPEN {
+		return nil
+	}
+	if len(s.writeChan) == s.writeChanSize {
+		s.log().Log(LOG_LEVEL_WARN, "unixgram write chan overflow, discard data", "dest", msg.destAddr, "size", len(msg.data))
+		return &StreamError{Kind: ERR_KIND_OVERFLOW, Op: "write", Err: ErrWriteOverflow}
+	}
+	s.writeChan <- msg
+	return nil
+}
+
+func (s *BaseUnixgramStream) WriteTo(data []byte, addr *net.UnixAddr) error {
+	return s.write(&unixgramMsg{data: data, destAddr: addr})
+}
+
+//addr: "/run/systemd/journal/socket"
+func (s *BaseUnixgramStream) WriteToAddr(data []byte, addr string) error {
+	unixAddr, err := net.ResolveUnixAddr("unixgram", addr)
+	if err != nil {
+		return err
+	}
+	return s.WriteTo(data, unixAddr)
+}
+
+func (s *BaseUnixgramStream) Close() {
+	if s.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		reason := s.closeState.reason()
+		s.log().Log(LOG_LEVEL_INFO, "unixgram closed", "local", s.Conn.LocalAddr(), "reason", reason)
+		s.Conn.Close()
+		close(s.writtingLoopCloseChan)
+		notifyClose(s.IBaseUnixgramStreamHandle, reason)
+	}
+}
+
+func (s *BaseUnixgramServer) Close() {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.BaseUnixgramStream.Close()
+		s.cleanup(s.Conn.LocalAddr().String())
+	}
+}
+
+func (s *BaseUnixgramServer) CloseWithError(err error) {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.closeState.record("close", err)
+	}
+	s.Close()
+}
+
+func (s *BaseUnixgramStream) CloseWithError(err error) {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.closeState.record("close", err)
+	}
+	s.Close()
+}
+
+//addr: "/tmp/logger.socket"
+func (s *BaseUnixgramServer) StartByAddr(addr string) error {
+	unixAddr, err := net.ResolveUnixAddr("unixgram", addr)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unixgram server resolve failed", "addr", addr, "err", err)
+		return err
+	}
+	if err = s.prepare("unixgram", addr); err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unixgram server bind failed", "addr", addr, "err", err)
+		return err
+	}
+	conn, err := net.ListenUnixgram("unixgram", unixAddr)
+	if err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unixgram server bind failed", "addr", addr, "err", err)
+		return err
+	}
+	if err = s.apply(addr); err != nil {
+		s.log().Log(LOG_LEVEL_ERROR, "unixgram server set socket file options failed", "addr", addr, "err", err)
+		conn.Close()
+		s.cleanup(addr)
+		return err
+	}
+	s.log().Log(LOG_LEVEL_INFO, "unixgram server bind successed", "addr", addr)
+	s.start(conn)
+	return nil
+}
+
+//本端不绑定地址，只能发送，例如 sd_notify
+//addr: "/run/systemd/notify"
+func (c *BaseUnixgramClient) ConnectByAddr(addr string) error {
+	return c.ConnectByAddrWithLocal("", addr)
+}
+
+//local 不为空时绑定本端地址，对端可以回复
+func (c *BaseUnixgramClient) ConnectByAddrWithLocal(local string, addr string) error {
+	c.RemoteAddress = addr
+	raddr, err := net.ResolveUnixAddr("unixgram", addr)
+	if err != nil {
+		c.log().Log(LOG_LEVEL_WARN, "unixgram client resolve failed", "addr", addr, "err", err)
+		return err
+	}
+	var laddr *net.UnixAddr
+	if local != "" {
+		if laddr, err = net.ResolveUnixAddr("unixgram", local); err != nil {
+			c.log().Log(LOG_LEVEL_WARN, "unixgram client resolve failed", "addr", local, "err", err)
+			return err
+		}
+	}
+	conn, err := net.DialUnix("unixgram", laddr, raddr)
+	if err != nil {
+		c.log().Log(LOG_LEVEL_WARN, "unixgram client connect failed", "addr", addr, "err", err)
+		return err
+	}
+	c.log().Log(LOG_LEVEL_INFO, "unixgram client connected", "addr", addr, "local", local)
+	c.start(conn)
+	return nil
+}
+
+//发送到 ConnectByAddr 的对端
+func (c *BaseUnixgramClient) Write(data []byte) (int, error) {
+	if err := c.write(&unixgramMsg{data: data}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (c *BaseUnixgramClient) WriteString(data string) (int, error) {
+	return c.Write([]byte(data))
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+// base_socket_unix_packet_linux.go
+package gobase
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+//unixpacket 的空消息和对端关闭都读到 0 字节，对端关闭或 shutdown 写端后 poll 返回 POLLRDHUP
+func unixPacketPeerClosed(conn *net.UnixConn) bool {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return true
+	}
+	closed := true
+	rc.Control(func(fd uintptr) {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLRDHUP}}
+		if n, err := unix.Poll(fds, 0); err == nil {
+			closed = n > 0 && fds[0].Revents&(unix.POLLRDHUP|unix.POLLHUP|unix.POLLERR) != 0
+		}
+	})
+	return closed
+}
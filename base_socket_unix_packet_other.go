@@ -0,0 +1,11 @@
+//go:build !linux
+
+// base_socket_unix_packet_other.go
+package gobase
+
+import "net"
+
+//无法区分空消息和对端关闭，读到 0 字节都按对端关闭处理
+func unixPacketPeerClosed(conn *net.UnixConn) bool {
+	return true
+}
@@ -0,0 +1,176 @@
+// base_socket_unix_packet_test.go
+package gobase
+
+import (
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+//BaseUnixgramClient 可以作为 io.Writer 使用
+var (
+	_ io.Writer       = &BaseUnixgramClient{}
+	_ io.StringWriter = &BaseUnixgramClient{}
+)
+
+type unixgramEchoHandle struct {
+	BaseUnixgramHandle
+	server *BaseUnixgramServer
+	reads  chan []byte
+}
+
+func (h *unixgramEchoHandle) OnRead(data []byte, addr *net.UnixAddr) {
+	h.reads <- data
+	if addr != nil {
+		h.server.WriteTo(data, addr)
+	}
+}
+
+type unixgramRecordHandle struct {
+	BaseUnixgramHandle
+	reads chan []byte
+}
+
+func (h *unixgramRecordHandle) OnRead(data []byte, addr *net.UnixAddr) {
+	h.reads <- data
+}
+
+func waitUnixRead(t *testing.T, reads chan []byte, want string) {
+	select {
+	case data := <-reads:
+		if string(data) != want {
+			t.Fatalf("unexpected message %q, want %q", data, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message %q not received", want)
+	}
+}
+
+func Test_UnixgramEcho(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram not supported")
+	}
+	dir := t.TempDir()
+	s := &BaseUnixgramServer{}
+	sh := &unixgramEchoHandle{server: s, reads: make(chan []byte, 4)}
+	s.IBaseUnixgramStreamHandle = sh
+	if err := s.StartByAddr(filepath.Join(dir, "server.sock")); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	//没有绑定本端地址的客户端只能发送
+	notify := &BaseUnixgramClient{}
+	if err := notify.ConnectByAddr(filepath.Join(dir, "server.sock")); err != nil {
+		t.Fatal(err)
+	}
+	defer notify.Close()
+	notify.WriteString("READY=1")
+	waitUnixRead(t, sh.reads, "READY=1")
+
+	ch := &unixgramRecordHandle{reads: make(chan []byte, 4)}
+	c := &BaseUnixgramClient{}
+	c.IBaseUnixgramStreamHandle = ch
+	if err := c.ConnectByAddrWithLocal(filepath.Join(dir, "client.sock"), filepath.Join(dir, "server.sock")); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.WriteString("ping")
+	waitUnixRead(t, sh.reads, "ping")
+	waitUnixRead(t, ch.reads, "ping")
+}
+
+type unixPacketRecordHandle struct {
+	BaseUnixSessionHandle
+	reads  chan []byte
+	errs   chan error
+}
+
+func (h *unixPacketRecordHandle) OnRead(data []byte) {
+	h.reads <- data
+}
+
+func (h *unixPacketRecordHandle) OnException(err error) {
+	if h.errs != nil {
+		h.errs <- err
+	}
+}
+
+type unixPacketServerHandle struct {
+	BaseUnixServerHandle
+	session *unixPacketRecordHandle
+}
+
+func (h *unixPacketServerHandle) OnAccept(conn net.Conn) {
+	session := &BaseUnixSession{}
+	session.Conn = conn
+	session.IBaseUnixStreamHandle = h.session
+	session.Start()
+}
+
+func Test_UnixPacketPreservesBoundaries(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unixpacket only tested on linux")
+	}
+	path := filepath.Join(t.TempDir(), "packet.sock")
+	session := &unixPacketRecordHandle{reads: make(chan []byte, 8)}
+	s := &BaseUnixServer{Network: "unixpacket", IBaseUnixServerHandle: &unixPacketServerHandle{session: session}}
+	if err := s.StartByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := &BaseUnixClient{Network: "unixpacket"}
+	c.IBaseUnixStreamHandle = &BaseUnixClientHandle{}
+	if err := c.ConnectByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	messages := []string{"first", "second", string(make([]byte, 5000))}
+	for _, msg := range messages {
+		c.Write([]byte(msg))
+	}
+	for _, msg := range messages {
+		waitUnixRead(t, session.reads, msg)
+	}
+}
+
+func Test_UnixPacketEmptyMessage(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unixpacket only tested on linux")
+	}
+	path := filepath.Join(t.TempDir(), "packet.sock")
+	session := &unixPacketRecordHandle{reads: make(chan []byte, 8), errs: make(chan error, 1)}
+	s := &BaseUnixServer{Network: "unixpacket", IBaseUnixServerHandle: &unixPacketServerHandle{session: session}}
+	if err := s.StartByAddr(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("unixpacket", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//空消息不是对端关闭，之后的消息照常收到
+	for _, msg := range []string{"", "after"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		waitUnixRead(t, session.reads, msg)
+	}
+	//对端关闭仍然是 io.EOF
+	conn.Close()
+	select {
+	case err := <-session.errs:
+		if !errors.Is(err, io.EOF) {
+			t.Fatalf("expect EOF, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer close not reported")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+// base_socket_unix_rights_other.go
+package gobase
+
+import (
+	"errors"
+	"os"
+)
+
+type unixRights struct {
+	oob []byte
+}
+
+func newUnixRights(files []*os.File) (*unixRights, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (r *unixRights) release() {
+}
+
+func unixRightsSpace() int {
+	return 0
+}
+
+func parseUnixRights(oob []byte, flags int) ([]*os.File, error) {
+	return nil, nil
+}
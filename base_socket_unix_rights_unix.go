@@ -0,0 +1,80 @@
+//go:build unix
+
+// base_socket_unix_rights_unix.go
+package gobase
+
+import (
+	"os"
+	"syscall"
+)
+
+//复制的文件描述符，发送后关闭
+type unixRights struct {
+	fds []int
+	oob []byte
+}
+
+func newUnixRights(files []*os.File) (*unixRights, error) {
+	r := &unixRights{}
+	for _, f := range files {
+		rc, err := f.SyscallConn()
+		if err != nil {
+			r.release()
+			return nil, err
+		}
+		var dupErr error
+		if err = rc.Control(func(fd uintptr) {
+			var dup int
+			if dup, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+				syscall.CloseOnExec(dup)
+				r.fds = append(r.fds, dup)
+			}
+		}); err == nil {
+			err = dupErr
+		}
+		if err != nil {
+			r.release()
+			return nil, err
+		}
+	}
+	r.oob = syscall.UnixRights(r.fds...)
+	return r, nil
+}
+
+func (r *unixRights) release() {
+	for _, fd := range r.fds {
+		syscall.Close(fd)
+	}
+	r.fds = nil
+}
+
+func unixRightsSpace() int {
+	return syscall.CmsgSpace(UNIX_MAX_FDS * 4)
+}
+
+//控制消息被截断时返回已经收到的文件和 ErrUnixRightsTruncated
+func parseUnixRights(oob []byte, flags int) ([]*os.File, error) {
+	var truncated error
+	if flags&syscall.MSG_CTRUNC != 0 {
+		truncated = ErrUnixRightsTruncated
+	}
+	if len(oob) == 0 {
+		return nil, truncated
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			syscall.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "unix-rights"))
+		}
+	}
+	return files, truncated
+}
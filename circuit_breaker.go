@@ -0,0 +1,277 @@
+// circuit_breaker.go
+package gobase
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CIRCUIT_CONSECUTIVE_FAILURES = 5
+	DEFAULT_CIRCUIT_OPEN_TIMEOUT         = 10 //unit: second
+	DEFAULT_CIRCUIT_WINDOW               = 60 //unit: second
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitState int
+
+const (
+	CIRCUIT_CLOSED CircuitState = iota
+	CIRCUIT_OPEN
+	CIRCUIT_HALF_OPEN
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CIRCUIT_CLOSED:
+		return "closed"
+	case CIRCUIT_OPEN:
+		return "open"
+	case CIRCUIT_HALF_OPEN:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+//连接失败时 Err 为 ErrCircuitOpen
+type CircuitOpenError struct {
+	Addr string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "connect " + e.Addr + ": " + ErrCircuitOpen.Error()
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+//按远端地址熔断，同一个 CircuitBreaker 可以被多个 BaseTCPClient、BaseUnixClient 共享
+type CircuitBreaker struct {
+	ConsecutiveFailures int           //连续失败次数达到后打开，0 表示不按连续失败判断
+	FailureRatio        float64       //Window 内失败比例达到后打开，0 表示不按比例判断
+	MinRequests         int           //按比例判断时 Window 内最少的请求数
+	Window              time.Duration //统计失败比例的窗口，关闭状态空闲超过 Window 的地址会被移除，unit: second
+	OpenTimeOut         time.Duration //打开后经过多久进入半开状态，unit: second
+	HalfOpenMaxProbes   int           //半开状态下同时允许的探测连接数
+	OnStateChange       func(addr string, from CircuitState, to CircuitState)
+
+	mu        sync.Mutex
+	circuits  map[string]*circuit
+	changes   []circuitStateChange
+	lastSweep time.Time
+}
+
+type circuitStateChange struct {
+	addr string
+	from CircuitState
+	to   CircuitState
+}
+
+type circuit struct {
+	state       CircuitState
+	generation  uint64
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probes      int
+	inflight    int //Allow 之后还没有 done 的连接数
+	lastUsed    time.Time
+}
+
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		ConsecutiveFailures: DEFAULT_CIRCUIT_CONSECUTIVE_FAILURES,
+		Window:              DEFAULT_CIRCUIT_WINDOW,
+		OpenTimeOut:         DEFAULT_CIRCUIT_OPEN_TIMEOUT,
+		HalfOpenMaxProbes:   1,
+	}
+}
+
+func (b *CircuitBreaker) State(addr string) CircuitState {
+	now := time.Now()
+	b.mu.Lock()
+	c := b.circuit(addr, now)
+	b.refresh(addr, c, now)
+	state := c.state
+	b.unlockAndNotify()
+	return state
+}
+
+//允许连接时返回 done，连接结束后必须调用 done(err)；熔断打开时返回 *CircuitOpenError
+//err 为 context.Canceled 时表示调用方主动放弃，既不算成功也不算失败
+func (b *CircuitBreaker) Allow(addr string) (done func(err error), err error) {
+	now := time.Now()
+	b.mu.Lock()
+	c := b.circuit(addr, now)
+	b.refresh(addr, c, now)
+	switch c.state {
+	case CIRCUIT_OPEN:
+		b.unlockAndNotify()
+		return nil, &CircuitOpenError{Addr: addr}
+	case CIRCUIT_HALF_OPEN:
+		maxProbes := b.HalfOpenMaxProbes
+		if maxProbes <= 0 {
+			maxProbes = 1
+		}
+		if c.probes >= maxProbes {
+			b.unlockAndNotify()
+			return nil, &CircuitOpenError{Addr: addr}
+		}
+		c.probes++
+	}
+	c.inflight++
+	generation := c.generation
+	b.unlockAndNotify()
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			b.report(addr, generation, err)
+		})
+	}, nil
+}
+
+//包装 d，经过它的每次连接都受熔断控制，可用于 http.Transport.DialContext
+func (b *CircuitBreaker) WrapDialer(d ContextDialer) ContextDialer {
+	return &circuitDialer{breaker: b, dialer: forwardDialer(d)}
+}
+
+type circuitDialer struct {
+	breaker *CircuitBreaker
+	dialer  ContextDialer
+}
+
+func (d *circuitDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	done, err := d.breaker.Allow(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err != nil && ctx.Err() == context.Canceled {
+		err = &net.OpError{Op: "dial", Net: network, Err: context.Canceled}
+	}
+	done(err)
+	return conn, err
+}
+
+func (b *CircuitBreaker) circuit(addr string, now time.Time) *circuit {
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	b.sweep(now)
+	c, ok := b.circuits[addr]
+	if !ok {
+		c = &circuit{windowStart: now}
+		b.circuits[addr] = c
+	}
+	c.lastUsed = now
+	return c
+}
+
+//移除关闭状态、没有进行中的连接且空闲超过 Window 的地址，最多每个 Window 检查一次
+func (b *CircuitBreaker) sweep(now time.Time) {
+	idle := b.Window * time.Second
+	if b.Window <= 0 {
+		idle = DEFAULT_CIRCUIT_WINDOW * time.Second
+	}
+	if now.Sub(b.lastSweep) < idle {
+		return
+	}
+	b.lastSweep = now
+	for addr, c := range b.circuits {
+		if c.state == CIRCUIT_CLOSED && c.inflight == 0 && now.Sub(c.lastUsed) >= idle {
+			delete(b.circuits, addr)
+		}
+	}
+}
+
+//打开超时后进入半开，关闭状态下窗口到期后重新计数
+func (b *CircuitBreaker) refresh(addr string, c *circuit, now time.Time) {
+	switch c.state {
+	case CIRCUIT_OPEN:
+		if now.Sub(c.openedAt) >= b.OpenTimeOut*time.Second {
+			b.setState(addr, c, CIRCUIT_HALF_OPEN, now)
+		}
+	case CIRCUIT_CLOSED:
+		if b.Window > 0 && now.Sub(c.windowStart) >= b.Window*time.Second {
+			c.requests, c.failures = 0, 0
+			c.windowStart = now
+		}
+	}
+}
+
+func (b *CircuitBreaker) report(addr string, generation uint64, err error) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.unlockAndNotify()
+	c := b.circuit(addr, now)
+	c.inflight--
+	if c.generation != generation {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		if c.state == CIRCUIT_HALF_OPEN {
+			c.probes--
+		}
+		return
+	}
+	if c.state == CIRCUIT_HALF_OPEN {
+		c.probes--
+		if err == nil {
+			b.setState(addr, c, CIRCUIT_CLOSED, now)
+		} else {
+			b.setState(addr, c, CIRCUIT_OPEN, now)
+		}
+		return
+	}
+
+	c.requests++
+	if err == nil {
+		c.consecutive = 0
+		return
+	}
+	c.failures++
+	c.consecutive++
+	if b.ConsecutiveFailures > 0 && c.consecutive >= b.ConsecutiveFailures {
+		b.setState(addr, c, CIRCUIT_OPEN, now)
+		return
+	}
+	if b.FailureRatio > 0 && c.requests >= b.MinRequests &&
+		float64(c.failures)/float64(c.requests) >= b.FailureRatio {
+		b.setState(addr, c, CIRCUIT_OPEN, now)
+	}
+}
+
+//调用时持有 b.mu，OnStateChange 在 unlockAndNotify 中回调
+func (b *CircuitBreaker) setState(addr string, c *circuit, state CircuitState, now time.Time) {
+	from := c.state
+	if from == state {
+		return
+	}
+	c.state = state
+	c.generation++
+	c.consecutive, c.requests, c.failures, c.probes = 0, 0, 0, 0
+	c.windowStart = now
+	if state == CIRCUIT_OPEN {
+		c.openedAt = now
+	}
+	b.changes = append(b.changes, circuitStateChange{addr: addr, from: from, to: state})
+}
+
+func (b *CircuitBreaker) unlockAndNotify() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	if b.OnStateChange != nil {
+		for _, change := range changes {
+			b.OnStateChange(change.addr, change.from, change.to)
+		}
+	}
+}
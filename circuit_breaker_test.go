@@ -0,0 +1,119 @@
+// circuit_breaker_test.go
+package gobase
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_CircuitBreakerStates(t *testing.T) {
+	var mu sync.Mutex
+	var changes []CircuitState
+	b := NewCircuitBreaker()
+	b.ConsecutiveFailures = 3
+	b.OpenTimeOut = 0
+	b.OnStateChange = func(addr string, from CircuitState, to CircuitState) {
+		mu.Lock()
+		changes = append(changes, to)
+		mu.Unlock()
+	}
+	addr := "10.0.0.1:80"
+	failed := errors.New("connection refused")
+	for i := 0; i < 3; i++ {
+		done, err := b.Allow(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(failed)
+	}
+	//OpenTimeOut 为 0，下一次检查立即进入半开
+	if state := b.State(addr); state != CIRCUIT_HALF_OPEN {
+		t.Fatalf("unexpected state %s", state)
+	}
+	probe, err := b.Allow(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Allow(addr); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("only one probe allowed in half-open, got %v", err)
+	}
+	probe(nil)
+	if state := b.State(addr); state != CIRCUIT_CLOSED {
+		t.Fatalf("unexpected state %s", state)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []CircuitState{CIRCUIT_OPEN, CIRCUIT_HALF_OPEN, CIRCUIT_CLOSED}
+	if len(changes) != len(want) {
+		t.Fatalf("unexpected changes %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("unexpected changes %v", changes)
+		}
+	}
+}
+
+func Test_CircuitBreakerFailureRatio(t *testing.T) {
+	b := &CircuitBreaker{FailureRatio: 0.5, MinRequests: 4, Window: 60, OpenTimeOut: 60}
+	addr := "10.0.0.2:80"
+	for _, err := range []error{nil, errors.New("refused"), nil, errors.New("refused")} {
+		done, _ := b.Allow(addr)
+		done(err)
+	}
+	if state := b.State(addr); state != CIRCUIT_OPEN {
+		t.Fatalf("unexpected state %s", state)
+	}
+}
+
+func Test_TCPClientCircuitBreaker(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	b := NewCircuitBreaker()
+	b.ConsecutiveFailures = 2
+	c := &BaseTCPClient{CircuitBreaker: b}
+	c.IBaseTCPStreamHandle = &BaseTCPClientHandle{}
+	for i := 0; i < 2; i++ {
+		if err := c.ConnectByAddrTimeOut(addr, 1, DEFAULT_DEADLINE); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("unexpected err %v", err)
+		}
+	}
+	start := time.Now()
+	if err := c.ConnectByAddrTimeOut(addr, 1, DEFAULT_DEADLINE); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expect circuit open, got %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("open circuit should fail fast")
+	}
+}
+
+func Test_CircuitBreakerEvictsIdleCircuits(t *testing.T) {
+	b := NewCircuitBreaker()
+	b.Window = 1
+	b.ConsecutiveFailures = 1
+	idle, _ := b.Allow("10.0.0.1:80")
+	idle(nil)
+	open, _ := b.Allow("10.0.0.2:80")
+	open(errors.New("refused"))
+	inflight, _ := b.Allow("10.0.0.3:80")
+
+	later := time.Now().Add(2 * time.Second)
+	b.mu.Lock()
+	b.sweep(later)
+	_, idleKept := b.circuits["10.0.0.1:80"]
+	_, openKept := b.circuits["10.0.0.2:80"]
+	_, inflightKept := b.circuits["10.0.0.3:80"]
+	b.mu.Unlock()
+	if idleKept || !openKept || !inflightKept {
+		t.Fatalf("unexpected eviction idle %v open %v inflight %v", idleKept, openKept, inflightKept)
+	}
+	inflight(nil)
+	if b.State("10.0.0.2:80") != CIRCUIT_OPEN {
+		t.Fatal("open circuit should not be evicted")
+	}
+}
@@ -0,0 +1,64 @@
+// gobasetest_test.go
+package gobasetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shiwei0124/gobase"
+)
+
+type echoSessionHandle struct {
+	gobase.BaseTCPSessionHandle
+	session *gobase.BaseTCPSession
+}
+
+func (h *echoSessionHandle) OnRead(data []byte) {
+	h.session.Write(append([]byte(nil), data...))
+}
+
+func (h *echoSessionHandle) OnException(err error) {
+	h.session.Close()
+}
+
+func Test_EchoServer(t *testing.T) {
+	VerifyNoStreamLeaks(t)
+	_, _, c, recording := StartServerAndClient(t, func(session *gobase.BaseTCPSession) gobase.IBaseTCPSessionHandle {
+		return &echoSessionHandle{session: session}
+	})
+	c.Write([]byte("hello"))
+	recording.WaitForData(t, "hello")
+}
+
+func Test_RecordingSessionCloseReason(t *testing.T) {
+	VerifyNoStreamLeaks(t)
+	s := StartServer(t, nil)
+	c, _ := s.Connect(t, nil)
+	session := s.WaitForRecordingSession(t)
+	session.WaitForEvent(t, EVENT_START)
+
+	c.Write([]byte("ping"))
+	session.WaitForData(t, "ping")
+	c.Close()
+	if reason := session.WaitForClose(t); reason.Initiator != gobase.CLOSE_BY_PEER {
+		t.Fatalf("unexpected close reason: %s", reason)
+	}
+}
+
+func Test_ServerAcceptsManyPendingSessions(t *testing.T) {
+	s := StartServer(t, nil)
+	const count = 100
+	for i := 0; i < count; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_WAIT_TIMEOUT)
+		conn, err := s.Listener.DialContext(ctx, "tcp", s.Listener.Addr().String())
+		cancel()
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		defer conn.Close()
+	}
+	for i := 0; i < count; i++ {
+		s.WaitForRecordingSession(t)
+		s.WaitForSession(t)
+	}
+}
@@ -0,0 +1,53 @@
+// leak.go
+package gobasetest
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+//在测试开始时调用，测试结束（包括其它 Cleanup 执行完）后检查
+//BaseTCPStream/BaseUnixStream 的 readLoop、writeLoop 是否都已退出
+func VerifyNoStreamLeaks(t testing.TB) {
+	t.Helper()
+	before := len(StreamGoroutines())
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stacks := StreamGoroutines()
+			if len(stacks) <= before {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%d stream goroutines leaked:\n%s", len(stacks)-before, strings.Join(stacks, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+//当前所有处于 stream 读写循环中的 goroutine 的调用栈
+func StreamGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var res []string
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		stack := string(g)
+		if strings.Contains(stack, "gobase.(*Base") &&
+			(strings.Contains(stack, ").readLoop(") || strings.Contains(stack, ").writeLoop(")) {
+			res = append(res, stack)
+		}
+	}
+	return res
+}
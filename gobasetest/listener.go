@@ -0,0 +1,99 @@
+// listener.go
+package gobasetest
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+)
+
+//内存中的地址，Network 为 "mem"
+type Addr string
+
+func (a Addr) Network() string {
+	return "mem"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+//基于 net.Pipe 的内存 listener，同时实现 gobase.ContextDialer，
+//可以直接作为 BaseTCPClient.Dialer 使用
+type Listener struct {
+	addr   Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	seq    int
+}
+
+func NewListener(name string) *Listener {
+	return &Listener{
+		addr:   Addr(name),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: "mem", Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *Listener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background(), "mem", string(l.addr))
+}
+
+//忽略 network 和 addr，总是连接到本 listener
+func (l *Listener) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	l.mu.Lock()
+	l.seq++
+	clientAddr := Addr(string(l.addr) + "-client-" + strconv.Itoa(l.seq))
+	l.mu.Unlock()
+
+	server, client := net.Pipe()
+	select {
+	case l.conns <- &conn{Conn: server, local: l.addr, remote: clientAddr}:
+		return &conn{Conn: client, local: clientAddr, remote: l.addr}, nil
+	case <-l.closed:
+		server.Close()
+		client.Close()
+		return nil, &net.OpError{Op: "dial", Net: "mem", Addr: l.addr, Err: net.ErrClosed}
+	case <-ctx.Done():
+		server.Close()
+		client.Close()
+		return nil, &net.OpError{Op: "dial", Net: "mem", Addr: l.addr, Err: ctx.Err()}
+	}
+}
+
+type conn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,204 @@
+// recorder.go
+package gobasetest
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shiwei0124/gobase"
+)
+
+const DEFAULT_WAIT_TIMEOUT = 5 * time.Second
+
+type EventType int
+
+const (
+	EVENT_START EventType = iota
+	EVENT_CONNECT
+	EVENT_READ
+	EVENT_EXCEPTION
+	EVENT_CLOSE
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EVENT_START:
+		return "OnStart"
+	case EVENT_CONNECT:
+		return "OnConnect"
+	case EVENT_READ:
+		return "OnRead"
+	case EVENT_EXCEPTION:
+		return "OnException"
+	case EVENT_CLOSE:
+		return "OnClose"
+	}
+	return "Unknown"
+}
+
+type Event struct {
+	Type      EventType
+	Data      []byte             //EVENT_READ
+	Err       error              //EVENT_EXCEPTION
+	Connected bool               //EVENT_CONNECT
+	Reason    gobase.CloseReason //EVENT_CLOSE
+	Time      time.Time
+}
+
+//按顺序记录回调事件，WaitFor 系列方法等待满足条件的事件出现
+type Recorder struct {
+	mu      sync.Mutex
+	events  []Event
+	changed chan struct{}
+}
+
+func (r *Recorder) record(e Event) {
+	e.Time = time.Now()
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+	r.mu.Unlock()
+}
+
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+//所有 OnRead 收到的数据按顺序拼接
+func (r *Recorder) Data() []byte {
+	return readData(r.Events())
+}
+
+func readData(events []Event) []byte {
+	var data []byte
+	for _, e := range events {
+		if e.Type == EVENT_READ {
+			data = append(data, e.Data...)
+		}
+	}
+	return data
+}
+
+//等待 pred 返回 true，超时后 t.Fatalf
+func (r *Recorder) WaitFor(t testing.TB, timeout time.Duration, desc string, pred func(events []Event) bool) []Event {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		r.mu.Lock()
+		events := append([]Event(nil), r.events...)
+		if r.changed == nil {
+			r.changed = make(chan struct{})
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		if pred(events) {
+			return events
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("wait for %s timeout after %s, events: %v", desc, timeout, events)
+			return events
+		}
+	}
+}
+
+//等待收到的数据中包含 want
+func (r *Recorder) WaitForData(t testing.TB, want string) {
+	t.Helper()
+	r.WaitFor(t, DEFAULT_WAIT_TIMEOUT, "data "+want, func(events []Event) bool {
+		return bytes.Contains(readData(events), []byte(want))
+	})
+}
+
+func (r *Recorder) WaitForEvent(t testing.TB, eventType EventType) Event {
+	t.Helper()
+	var res Event
+	r.WaitFor(t, DEFAULT_WAIT_TIMEOUT, eventType.String(), func(events []Event) bool {
+		for _, e := range events {
+			if e.Type == eventType {
+				res = e
+				return true
+			}
+		}
+		return false
+	})
+	return res
+}
+
+func (r *Recorder) WaitForClose(t testing.TB) gobase.CloseReason {
+	t.Helper()
+	return r.WaitForEvent(t, EVENT_CLOSE).Reason
+}
+
+func (r *Recorder) WaitForException(t testing.TB) error {
+	t.Helper()
+	return r.WaitForEvent(t, EVENT_EXCEPTION).Err
+}
+
+//记录所有回调的 session handle，CloseOnException 为 true 时出错后关闭 session
+type RecordingSessionHandle struct {
+	Recorder
+	Session          *gobase.BaseTCPSession
+	CloseOnException bool
+}
+
+func (h *RecordingSessionHandle) OnStart() {
+	h.record(Event{Type: EVENT_START})
+}
+
+func (h *RecordingSessionHandle) OnRead(data []byte) {
+	h.record(Event{Type: EVENT_READ, Data: append([]byte(nil), data...)})
+}
+
+func (h *RecordingSessionHandle) OnException(err error) {
+	h.record(Event{Type: EVENT_EXCEPTION, Err: err})
+	if h.CloseOnException && h.Session != nil {
+		h.Session.Close()
+	}
+}
+
+func (h *RecordingSessionHandle) OnClose() {
+	h.record(Event{Type: EVENT_CLOSE})
+}
+
+func (h *RecordingSessionHandle) OnCloseWithReason(reason gobase.CloseReason) {
+	h.record(Event{Type: EVENT_CLOSE, Reason: reason})
+}
+
+type RecordingClientHandle struct {
+	Recorder
+	Client           *gobase.BaseTCPClient
+	CloseOnException bool
+}
+
+func (h *RecordingClientHandle) OnConnect(bConnected bool) {
+	h.record(Event{Type: EVENT_CONNECT, Connected: bConnected})
+}
+
+func (h *RecordingClientHandle) OnRead(data []byte) {
+	h.record(Event{Type: EVENT_READ, Data: append([]byte(nil), data...)})
+}
+
+func (h *RecordingClientHandle) OnException(err error) {
+	h.record(Event{Type: EVENT_EXCEPTION, Err: err})
+	if h.CloseOnException && h.Client != nil {
+		h.Client.Close()
+	}
+}
+
+func (h *RecordingClientHandle) OnClose() {
+	h.record(Event{Type: EVENT_CLOSE})
+}
+
+func (h *RecordingClientHandle) OnCloseWithReason(reason gobase.CloseReason) {
+	h.record(Event{Type: EVENT_CLOSE, Reason: reason})
+}
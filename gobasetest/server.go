@@ -0,0 +1,146 @@
+// server.go
+package gobasetest
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shiwei0124/gobase"
+)
+
+//为每个新连接创建 session handle，session 已经设置好 Conn，Start 由 Server 调用
+type SessionHandleFactory func(session *gobase.BaseTCPSession) gobase.IBaseTCPSessionHandle
+
+//运行在内存 listener 上的 BaseTCPServer
+type Server struct {
+	gobase.BaseTCPServerHandle
+	*gobase.BaseTCPServer
+	Listener   *Listener
+	newHandle  SessionHandleFactory
+	sessions   queue[*gobase.BaseTCPSession]
+	recordings queue[*RecordingSessionHandle]
+}
+
+//不限长度的队列，push 不会阻塞 accept
+type queue[T any] struct {
+	mu      sync.Mutex
+	items   []T
+	changed chan struct{}
+}
+
+func (q *queue[T]) push(v T) {
+	q.mu.Lock()
+	q.items = append(q.items, v)
+	if q.changed != nil {
+		close(q.changed)
+		q.changed = nil
+	}
+	q.mu.Unlock()
+}
+
+//超时返回 false
+func (q *queue[T]) pop(timeout time.Duration) (T, bool) {
+	deadline := time.After(timeout)
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			v := q.items[0]
+			var zero T
+			q.items[0] = zero
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return v, true
+		}
+		if q.changed == nil {
+			q.changed = make(chan struct{})
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			var zero T
+			return zero, false
+		}
+	}
+}
+
+//newHandle 为 nil 时每个 session 使用 RecordingSessionHandle，可以通过 WaitForSession 取得；
+//测试结束时自动关闭
+func StartServer(t testing.TB, newHandle SessionHandleFactory) *Server {
+	t.Helper()
+	s := &Server{
+		Listener:  NewListener(t.Name()),
+		newHandle: newHandle,
+	}
+	s.BaseTCPServer = &gobase.BaseTCPServer{IBaseTCPServerHandle: s}
+	if err := s.StartByListener(s.Listener); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) OnAccept(c net.Conn) {
+	session := &gobase.BaseTCPSession{}
+	session.Conn = c
+	if s.newHandle != nil {
+		session.IBaseTCPStreamHandle = s.newHandle(session)
+	} else {
+		h := &RecordingSessionHandle{Session: session, CloseOnException: true}
+		session.IBaseTCPStreamHandle = h
+		s.recordings.push(h)
+	}
+	session.Start()
+	s.sessions.push(session)
+}
+
+//等待下一个被接受的 session
+func (s *Server) WaitForSession(t testing.TB) *gobase.BaseTCPSession {
+	t.Helper()
+	session, ok := s.sessions.pop(DEFAULT_WAIT_TIMEOUT)
+	if !ok {
+		t.Fatal("wait for session timeout")
+	}
+	return session
+}
+
+//等待下一个 RecordingSessionHandle，仅在 newHandle 为 nil 时可用
+func (s *Server) WaitForRecordingSession(t testing.TB) *RecordingSessionHandle {
+	t.Helper()
+	h, ok := s.recordings.pop(DEFAULT_WAIT_TIMEOUT)
+	if !ok {
+		t.Fatal("wait for session timeout")
+	}
+	return h
+}
+
+//建立一个连接到 s 的 BaseTCPClient，handle 为 nil 时使用 RecordingClientHandle；
+//测试结束时自动关闭
+func (s *Server) Connect(t testing.TB, handle gobase.IBaseTCPClientHandle) (*gobase.BaseTCPClient, *RecordingClientHandle) {
+	t.Helper()
+	c := &gobase.BaseTCPClient{Dialer: s.Listener}
+	var recording *RecordingClientHandle
+	if handle == nil {
+		recording = &RecordingClientHandle{Client: c, CloseOnException: true}
+		handle = recording
+	}
+	c.IBaseTCPStreamHandle = handle
+	if err := c.ConnectByAddr(s.Listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, recording
+}
+
+//一次调用启动 server 并得到一个已连接的 client 及其对应的服务端 session
+func StartServerAndClient(t testing.TB, newHandle SessionHandleFactory) (*Server, *gobase.BaseTCPSession, *gobase.BaseTCPClient, *RecordingClientHandle) {
+	t.Helper()
+	s := StartServer(t, newHandle)
+	c, recording := s.Connect(t, nil)
+	session := s.WaitForSession(t)
+	return s, session, c, recording
+}
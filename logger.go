@@ -0,0 +1,77 @@
+// logger.go
+package gobase
+
+import (
+	"context"
+	"log/slog"
+)
+
+type LogLevel int
+
+const (
+	LOG_LEVEL_DEBUG LogLevel = iota
+	LOG_LEVEL_INFO
+	LOG_LEVEL_WARN
+	LOG_LEVEL_ERROR
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LOG_LEVEL_DEBUG:
+		return "DEBUG"
+	case LOG_LEVEL_INFO:
+		return "INFO"
+	case LOG_LEVEL_WARN:
+		return "WARN"
+	case LOG_LEVEL_ERROR:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+//keyvals 为成对的 key, value，例如 "addr", "127.0.0.1:80", "err", err
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+//默认的空实现，不输出任何内容
+type NopLogger struct {
+}
+
+func (l NopLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+}
+
+func loggerOrNop(l Logger) Logger {
+	if l == nil {
+		return NopLogger{}
+	}
+	return l
+}
+
+//标准库 log/slog 的适配
+type SlogLogger struct {
+	*slog.Logger
+}
+
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{Logger: l}
+}
+
+func (l *SlogLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.Logger.Log(context.Background(), slogLevel(level), msg, keyvals...)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LOG_LEVEL_DEBUG:
+		return slog.LevelDebug
+	case LOG_LEVEL_INFO:
+		return slog.LevelInfo
+	case LOG_LEVEL_WARN:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
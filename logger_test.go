@@ -0,0 +1,41 @@
+// logger_test.go
+package gobase
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_SlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	logger.Log(LOG_LEVEL_WARN, "write chan overflow", "size", 10)
+	out := buf.String()
+	if !strings.Contains(out, "level=WARN") || !strings.Contains(out, `msg="write chan overflow"`) || !strings.Contains(out, "size=10") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func Test_TCPServerBindFailedLog(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	buf := &bytes.Buffer{}
+	s := &BaseTCPServer{
+		IBaseTCPServerHandle: &BaseTCPServerHandle{},
+		Logger:               NewSlogLogger(slog.New(slog.NewTextHandler(buf, nil))),
+	}
+	if err := s.StartByAddr(ln.Addr().String()); err == nil {
+		s.Close()
+		t.Fatal("bind should fail")
+	}
+	if !strings.Contains(buf.String(), "tcp server bind failed") {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
@@ -0,0 +1,230 @@
+// proxy_dialer_test.go
+package gobase
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//测试用的进程内代理，只实现 CONNECT
+type testProxy struct {
+	net.Listener
+	username string
+	password string
+	socks5   bool
+}
+
+func startTestProxy(t *testing.T, socks5 bool, username, password string) *testProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProxy{Listener: ln, username: username, password: password, socks5: socks5}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if p.socks5 {
+				go p.serveSOCKS5(conn)
+			} else {
+				go p.serveHTTP(conn)
+			}
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return p
+}
+
+func (p *testProxy) serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	if p.username == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != p.username || string(pass) != p.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 4:
+		io.ReadFull(conn, buf[:16])
+		host = net.IP(buf[:16]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	io.ReadFull(conn, buf[:2])
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipeTestConn(conn, upstream)
+}
+
+func (p *testProxy) serveHTTP(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	if p.username != "" {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.username+":"+p.password))
+		if req.Header.Get("Proxy-Authorization") != want {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return
+		}
+	}
+	upstream, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	pipeTestConn(&bufferedConn{Conn: conn, r: br}, upstream)
+}
+
+func pipeTestConn(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(a, b); done <- struct{}{} }()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
+
+func startEchoListener(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+type chanClientHandle struct {
+	BaseTCPClientHandle
+	data chan []byte
+}
+
+func (h *chanClientHandle) OnRead(data []byte) {
+	h.data <- append([]byte(nil), data...)
+}
+
+func Test_TCPClientProxyChain(t *testing.T) {
+	echo := startEchoListener(t)
+	socks := startTestProxy(t, true, "user", "pass")
+	httpProxy := startTestProxy(t, false, "user2", "pass2")
+
+	dialer, err := NewProxyChain("socks5://user:pass@"+socks.Addr().String(), "http://user2:pass2@"+httpProxy.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := &chanClientHandle{data: make(chan []byte, 1)}
+	c := &BaseTCPClient{Dialer: dialer}
+	c.IBaseTCPStreamHandle = handle
+	if err := c.ConnectByAddr(echo.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	select {
+	case data := <-handle.data:
+		if string(data) != "ping" {
+			t.Fatalf("unexpected echo: %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait echo timeout")
+	}
+}
+
+func Test_TCPClientLocalAddrWithDialer(t *testing.T) {
+	echo := startEchoListener(t)
+	socks := startTestProxy(t, true, "", "")
+	//设置了代理时不能绑定本端地址，不能绕过代理直连
+	c := &BaseTCPClient{Dialer: &SOCKS5Dialer{ProxyAddr: socks.Addr().String()}}
+	c.IBaseTCPStreamHandle = &BaseTCPClientHandle{}
+	if err := c.ConnectByAddrTimeOutWithLocal("", 0, echo.Addr().String(), 5, DEFAULT_DEADLINE); !errors.Is(err, ErrLocalAddrWithDialer) {
+		t.Fatalf("expect ErrLocalAddrWithDialer, got %v", err)
+	}
+}
+
+func Test_ProxyErrors(t *testing.T) {
+	echo := startEchoListener(t)
+	socks := startTestProxy(t, true, "user", "pass")
+	httpProxy := startTestProxy(t, false, "", "")
+	ctx := context.Background()
+
+	d := &SOCKS5Dialer{ProxyAddr: socks.Addr().String(), Username: "user", Password: "wrong"}
+	if _, err := d.DialContext(ctx, "tcp", echo.Addr().String()); !IsProxyError(err) {
+		t.Fatalf("expect proxy error, got %v", err)
+	}
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	d = &SOCKS5Dialer{ProxyAddr: socks.Addr().String(), Username: "user", Password: "pass"}
+	if _, err := d.DialContext(ctx, "tcp", closedAddr); !IsProxyTargetError(err) {
+		t.Fatalf("expect proxy target error, got %v", err)
+	}
+	h := &HTTPConnectDialer{ProxyAddr: httpProxy.Addr().String()}
+	if _, err := h.DialContext(ctx, "tcp", closedAddr); !IsProxyTargetError(err) {
+		t.Fatalf("expect proxy target error, got %v", err)
+	}
+	h = &HTTPConnectDialer{ProxyAddr: closedAddr}
+	if _, err := h.DialContext(ctx, "tcp", echo.Addr().String()); !IsProxyError(err) {
+		t.Fatalf("expect proxy error, got %v", err)
+	}
+}
@@ -0,0 +1,1024 @@
+// reliable_udp.go
+package gobase
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/// 基于 BaseUDPStream 的可靠 UDP（类似 KCP 的 ARQ）
+//
+//每个数据报包含一个或多个分段，分段头部：
+//conv(4) cmd(1) flags(1) channel(1) frg(1) wnd(2) len(2) ts(4) sn(4) una(4) csn(4)
+//sn 为会话内的发送序号，用于确认和重传；csn 为 channel 内的序号，用于有序 channel 的排序和分片重组
+
+const (
+	DEFAULT_RUDP_MTU          = 1400
+	DEFAULT_RUDP_INTERVAL     = 10 * time.Millisecond
+	DEFAULT_RUDP_WINDOW       = 128
+	DEFAULT_RUDP_MIN_RTO      = 30 * time.Millisecond
+	DEFAULT_RUDP_FAST_RESEND  = 2
+	DEFAULT_RUDP_DEAD_LINK    = 20
+	DEFAULT_RUDP_QUEUE_SIZE   = 4096
+	DEFAULT_RUDP_MAX_SESSIONS = 65536
+)
+
+const (
+	rudpHeaderSize   = 28
+	rudpMaxFragments = 128
+	rudpInitialRTO   = 200   //unit: millisecond
+	rudpMaxRTO       = 60000 //unit: millisecond
+	rudpInitialCwnd  = 4
+
+	rudpCmdPush  = 1
+	rudpCmdAck   = 2
+	rudpCmdPing  = 3 //保活和窗口探测
+	rudpCmdClose = 4
+
+	rudpFlagUnordered = 1
+)
+
+var ErrRUDPMessageTooLarge = errors.New("rudp message too large")
+var ErrRUDPDeadLink = fmt.Errorf("rudp retransmission limit reached: %w", os.ErrDeadlineExceeded)
+var errRUDPIdleTimeout = fmt.Errorf("rudp session idle timeout: %w", os.ErrDeadlineExceeded)
+
+type RUDPConfig struct {
+	MTU               int           //单个数据报的最大长度，两端需要一致
+	Interval          time.Duration //重传检查的间隔
+	SndWnd            int           //发送窗口，单位为分段
+	RcvWnd            int           //接收窗口，单位为分段
+	MinRTO            time.Duration
+	FastResend        int           //被后面的分段的 ACK 跳过多少次后立即重传，<0 时关闭快速重传
+	DeadLink          int           //单个分段发送次数超过后断开会话
+	NoCongestion      bool          //关闭拥塞控制，只受收发窗口限制
+	QueueSize         int           //等待发送的分段数上限，超过时 Write 返回 ErrWriteOverflow
+	DeadLine          time.Duration //没有收到任何数据的超时，空闲时每 DeadLine/4 发送一次保活，unit: second
+	UnorderedChannels []uint8       //这些 channel 上的消息到达即回调，不保证顺序，消息不能超过一个分段
+}
+
+func (c RUDPConfig) withDefaults() RUDPConfig {
+	if c.MTU <= rudpHeaderSize {
+		c.MTU = DEFAULT_RUDP_MTU
+	}
+	if c.MTU > UDP_MAX_DATAGRAM_SIZE {
+		c.MTU = UDP_MAX_DATAGRAM_SIZE
+	}
+	if c.Interval <= 0 {
+		c.Interval = DEFAULT_RUDP_INTERVAL
+	}
+	if c.SndWnd <= 0 {
+		c.SndWnd = DEFAULT_RUDP_WINDOW
+	}
+	if c.RcvWnd <= 0 {
+		c.RcvWnd = DEFAULT_RUDP_WINDOW
+	}
+	if c.MinRTO <= 0 {
+		c.MinRTO = DEFAULT_RUDP_MIN_RTO
+	}
+	if c.FastResend == 0 {
+		c.FastResend = DEFAULT_RUDP_FAST_RESEND
+	}
+	if c.DeadLink <= 0 {
+		c.DeadLink = DEFAULT_RUDP_DEAD_LINK
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = DEFAULT_RUDP_QUEUE_SIZE
+	}
+	if c.DeadLine == 0 {
+		c.DeadLine = DEFAULT_DEADLINE
+	}
+	return c
+}
+
+func (c *RUDPConfig) unordered(channel uint8) bool {
+	for _, ch := range c.UnorderedChannels {
+		if ch == channel {
+			return true
+		}
+	}
+	return false
+}
+
+type IBaseRUDPSessionHandle interface {
+	IBaseStreamHandle
+	OnRead(data []byte)
+}
+
+//handle 实现该接口时代替 OnRead 被调用，可以区分 channel
+type IBaseRUDPChannelHandle interface {
+	OnReadChannel(channel uint8, data []byte)
+}
+
+type BaseRUDPSessionHandle struct {
+	BaseIOStreamHandle
+}
+
+type rudpSegment struct {
+	conv    uint32
+	cmd     uint8
+	flags   uint8
+	channel uint8
+	frg     uint8
+	wnd     uint16
+	ts      uint32
+	sn      uint32
+	una     uint32
+	csn     uint32
+	data    []byte
+
+	//发送端状态
+	resendts uint32
+	rto      uint32
+	xmit     int
+	fastack  int
+}
+
+func (seg *rudpSegment) encode(buf []byte) []byte {
+	var h [rudpHeaderSize]byte
+	binary.BigEndian.PutUint32(h[0:], seg.conv)
+	h[4] = seg.cmd
+	h[5] = seg.flags
+	h[6] = seg.channel
+	h[7] = seg.frg
+	binary.BigEndian.PutUint16(h[8:], seg.wnd)
+	binary.BigEndian.PutUint16(h[10:], uint16(len(seg.data)))
+	binary.BigEndian.PutUint32(h[12:], seg.ts)
+	binary.BigEndian.PutUint32(h[16:], seg.sn)
+	binary.BigEndian.PutUint32(h[20:], seg.una)
+	binary.BigEndian.PutUint32(h[24:], seg.csn)
+	buf = append(buf, h[:]...)
+	return append(buf, seg.data...)
+}
+
+//data 引用 p，需要保存时由调用方拷贝
+func decodeRUDPSegment(p []byte) (seg rudpSegment, rest []byte, ok bool) {
+	if len(p) < rudpHeaderSize {
+		return seg, nil, false
+	}
+	seg.conv = binary.BigEndian.Uint32(p[0:])
+	seg.cmd = p[4]
+	seg.flags = p[5]
+	seg.channel = p[6]
+	seg.frg = p[7]
+	seg.wnd = binary.BigEndian.Uint16(p[8:])
+	length := int(binary.BigEndian.Uint16(p[10:]))
+	seg.ts = binary.BigEndian.Uint32(p[12:])
+	seg.sn = binary.BigEndian.Uint32(p[16:])
+	seg.una = binary.BigEndian.Uint32(p[20:])
+	seg.csn = binary.BigEndian.Uint32(p[24:])
+	if len(p) < rudpHeaderSize+length {
+		return seg, nil, false
+	}
+	seg.data = p[rudpHeaderSize : rudpHeaderSize+length]
+	return seg, p[rudpHeaderSize+length:], true
+}
+
+//序号回绕后仍然正确比较
+func rudpBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+//把分段拼成不超过 mtu 的数据报
+type rudpWriter struct {
+	mtu int
+	buf []byte
+	out [][]byte
+}
+
+func (w *rudpWriter) add(seg *rudpSegment) {
+	if len(w.buf) > 0 && len(w.buf)+rudpHeaderSize+len(seg.data) > w.mtu {
+		w.out = append(w.out, w.buf)
+		w.buf = nil
+	}
+	w.buf = seg.encode(w.buf)
+}
+
+func (w *rudpWriter) finish() [][]byte {
+	if len(w.buf) > 0 {
+		w.out = append(w.out, w.buf)
+		w.buf = nil
+	}
+	return w.out
+}
+
+type rudpAck struct {
+	sn uint32
+	ts uint32
+}
+
+type rudpRecvChannel struct {
+	next    uint32
+	pending map[uint32]*rudpSegment
+	partial []byte
+}
+
+type rudpDelivery struct {
+	channel uint8
+	data    []byte
+}
+
+type RUDPStats struct {
+	SRTT            time.Duration
+	RTO             time.Duration
+	Cwnd            int
+	RemoteWnd       int
+	InFlight        int
+	Queued          int
+	Retransmits     uint64
+	FastRetransmits uint64
+}
+
+//一个远端对应一个会话，Write/OnRead 的用法与 BaseTCPStream 一致，每次 OnRead 是一条完整的消息
+type BaseRUDPSession struct {
+	IBaseRUDPSessionHandle
+	Logger     Logger
+	endpoint   *rudpEndpoint
+	config     *RUDPConfig
+	addr       *net.UDPAddr
+	conv       uint32
+	closed     AtomicInt32
+	closeState streamCloseState
+	onClose    func()
+
+	mu              sync.Mutex
+	lastRecv        time.Time
+	lastSend        uint32
+	sndQueue        []*rudpSegment
+	sndBuf          []*rudpSegment
+	sndUna          uint32
+	sndNxt          uint32
+	sndCsn          [256]uint32
+	rmtWnd          uint32
+	cwnd            uint32
+	ssthresh        uint32
+	incr            uint32
+	srtt            int64
+	rttvar          int64
+	rto             uint32
+	acklist         []rudpAck
+	pingReply       bool
+	rcvNxt          uint32
+	rcvSeen         map[uint32]bool
+	channels        map[uint8]*rudpRecvChannel
+	retransmits     uint64
+	fastRetransmits uint64
+}
+
+func (s *BaseRUDPSession) init(e *rudpEndpoint, addr *net.UDPAddr, conv uint32) {
+	s.endpoint = e
+	s.config = &e.config
+	s.addr = addr
+	s.conv = conv
+	if s.Logger == nil {
+		s.Logger = e.logger
+	}
+	s.lastRecv = time.Now()
+	s.lastSend = e.now()
+	s.sndQueue, s.sndBuf, s.acklist = nil, nil, nil
+	s.sndUna, s.sndNxt, s.rcvNxt = 0, 0, 0
+	s.sndCsn = [256]uint32{}
+	s.rmtWnd = uint32(e.config.RcvWnd)
+	s.cwnd = rudpInitialCwnd
+	s.ssthresh = uint32(e.config.SndWnd)
+	s.incr = 0
+	s.srtt, s.rttvar = 0, 0
+	s.rto = rudpInitialRTO
+	s.rcvSeen = make(map[uint32]bool)
+	s.channels = make(map[uint8]*rudpRecvChannel)
+	s.retransmits, s.fastRetransmits = 0, 0
+	s.closeState.reset()
+	s.closed.Set(SOCKET_OPEN)
+}
+
+func (s *BaseRUDPSession) log() Logger {
+	return loggerOrNop(s.Logger)
+}
+
+func (s *BaseRUDPSession) RemoteAddr() net.Addr {
+	return s.addr
+}
+
+func (s *BaseRUDPSession) LocalAddr() net.Addr {
+	return s.endpoint.udp.LocalAddr()
+}
+
+func (s *BaseRUDPSession) Write(data []byte) error {
+	return s.WriteChannel(0, data)
+}
+
+func (s *BaseRUDPSession) WriteString(data string) error {
+	return s.WriteChannel(0, []byte(data))
+}
+
+//超过一个分段的消息在有序 channel 上分片发送，对端收齐后一次回调
+func (s *BaseRUDPSession) WriteChannel(channel uint8, data []byte) error {
+	if s.closed.Get() != SOCKET_OPEN {
+		return nil
+	}
+	mss := s.config.MTU - rudpHeaderSize
+	unordered := s.config.unordered(channel)
+	count := (len(data) + mss - 1) / mss
+	if count == 0 {
+		count = 1
+	}
+	if count > rudpMaxFragments || (unordered && count > 1) {
+		return ErrRUDPMessageTooLarge
+	}
+
+	s.mu.Lock()
+	if len(s.sndQueue)+count > s.config.QueueSize {
+		s.mu.Unlock()
+		err := NewStreamError("write", ErrWriteOverflow)
+		s.log().Log(LOG_LEVEL_WARN, "rudp session send queue overflow, discard data", "remote", s.addr, "size", len(data))
+		return err
+	}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * mss
+		if end > len(data) {
+			end = len(data)
+		}
+		seg := &rudpSegment{
+			cmd:     rudpCmdPush,
+			channel: channel,
+			frg:     uint8(count - 1 - i),
+			data:    append([]byte(nil), data[i*mss:end]...),
+		}
+		if unordered {
+			seg.flags |= rudpFlagUnordered
+		} else {
+			seg.csn = s.sndCsn[channel]
+			s.sndCsn[channel]++
+		}
+		s.sndQueue = append(s.sndQueue, seg)
+	}
+	s.mu.Unlock()
+	s.flush()
+	return nil
+}
+
+//未确认的数据会被丢弃，对端收到 close 分段后关闭
+func (s *BaseRUDPSession) Close() {
+	if s.closed.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		reason := s.closeState.reason()
+		s.log().Log(LOG_LEVEL_INFO, "rudp session closed", "remote", s.addr, "reason", reason)
+		if reason.Initiator == CLOSE_BY_LOCAL {
+			seg := rudpSegment{conv: s.conv, cmd: rudpCmdClose}
+			s.endpoint.send([][]byte{seg.encode(nil)}, s.addr)
+		}
+		s.endpoint.remove(s)
+		notifyClose(s.IBaseRUDPSessionHandle, reason)
+		if s.onClose != nil {
+			s.onClose()
+		}
+	}
+}
+
+func (s *BaseRUDPSession) CloseWithError(err error) {
+	if s.closed.Get() == SOCKET_OPEN {
+		s.closeState.record("close", err)
+	}
+	s.Close()
+}
+
+func (s *BaseRUDPSession) Stats() RUDPStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RUDPStats{
+		SRTT:            time.Duration(s.srtt) * time.Millisecond,
+		RTO:             time.Duration(s.rto) * time.Millisecond,
+		Cwnd:            int(s.cwnd),
+		RemoteWnd:       int(s.rmtWnd),
+		InFlight:        len(s.sndBuf),
+		Queued:          len(s.sndQueue),
+		Retransmits:     s.retransmits,
+		FastRetransmits: s.fastRetransmits,
+	}
+}
+
+func (s *BaseRUDPSession) input(data []byte) {
+	if s.closed.Get() != SOCKET_OPEN {
+		return
+	}
+	now := s.endpoint.now()
+	var deliveries []rudpDelivery
+	peerClosed := false
+
+	s.mu.Lock()
+	s.lastRecv = time.Now()
+	oldUna := s.sndUna
+	var maxAck uint32
+	ackFound := false
+	for len(data) > 0 {
+		seg, rest, ok := decodeRUDPSegment(data)
+		if !ok || seg.conv != s.conv {
+			break
+		}
+		data = rest
+		s.rmtWnd = uint32(seg.wnd)
+		s.parseUna(seg.una)
+		switch seg.cmd {
+		case rudpCmdAck:
+			if !rudpBefore(now, seg.ts) {
+				s.updateRTT(int64(now - seg.ts))
+			}
+			s.parseAck(seg.sn)
+			if !ackFound || rudpBefore(maxAck, seg.sn) {
+				maxAck, ackFound = seg.sn, true
+			}
+		case rudpCmdPush:
+			diff := int32(seg.sn - s.rcvNxt)
+			if diff >= int32(s.config.RcvWnd) {
+				continue
+			}
+			s.acklist = append(s.acklist, rudpAck{sn: seg.sn, ts: seg.ts})
+			if diff >= 0 && !s.rcvSeen[seg.sn] {
+				s.rcvSeen[seg.sn] = true
+				for s.rcvSeen[s.rcvNxt] {
+					delete(s.rcvSeen, s.rcvNxt)
+					s.rcvNxt++
+				}
+				seg.data = append([]byte(nil), seg.data...)
+				deliveries = s.receive(&seg, deliveries)
+			}
+		case rudpCmdPing:
+			s.pingReply = true
+		case rudpCmdClose:
+			peerClosed = true
+		}
+	}
+	if ackFound {
+		s.parseFastAck(maxAck)
+	}
+	if rudpBefore(oldUna, s.sndUna) {
+		s.growCwnd()
+	}
+	s.mu.Unlock()
+
+	for _, d := range deliveries {
+		if h, ok := s.IBaseRUDPSessionHandle.(IBaseRUDPChannelHandle); ok {
+			h.OnReadChannel(d.channel, d.data)
+		} else if s.IBaseRUDPSessionHandle != nil {
+			s.IBaseRUDPSessionHandle.OnRead(d.data)
+		}
+	}
+	if peerClosed {
+		s.closeState.record("read", io.EOF)
+		s.Close()
+		return
+	}
+	s.flush()
+}
+
+//调用时持有 s.mu
+func (s *BaseRUDPSession) receive(seg *rudpSegment, out []rudpDelivery) []rudpDelivery {
+	if seg.flags&rudpFlagUnordered != 0 {
+		return append(out, rudpDelivery{channel: seg.channel, data: seg.data})
+	}
+	ch := s.channels[seg.channel]
+	if ch == nil {
+		ch = &rudpRecvChannel{pending: make(map[uint32]*rudpSegment)}
+		s.channels[seg.channel] = ch
+	}
+	//csn 不受 sn 的窗口限制，正常的对端 csn 不会超出 ch.next 一个接收窗口，超出的丢弃，避免 pending 无限增长
+	if rudpBefore(seg.csn, ch.next) || seg.csn-ch.next >= uint32(s.config.RcvWnd) {
+		return out
+	}
+	ch.pending[seg.csn] = seg
+	for {
+		p := ch.pending[ch.next]
+		if p == nil {
+			break
+		}
+		delete(ch.pending, ch.next)
+		ch.next++
+		if p.frg == 0 && ch.partial == nil {
+			out = append(out, rudpDelivery{channel: p.channel, data: p.data})
+			continue
+		}
+		ch.partial = append(ch.partial, p.data...)
+		if p.frg == 0 {
+			out = append(out, rudpDelivery{channel: p.channel, data: ch.partial})
+			ch.partial = nil
+		}
+	}
+	return out
+}
+
+func (s *BaseRUDPSession) parseUna(una uint32) {
+	n := 0
+	for n < len(s.sndBuf) && rudpBefore(s.sndBuf[n].sn, una) {
+		n++
+	}
+	if n > 0 {
+		s.sndBuf = append(s.sndBuf[:0], s.sndBuf[n:]...)
+	}
+	s.shrinkBuf()
+}
+
+func (s *BaseRUDPSession) parseAck(sn uint32) {
+	if rudpBefore(sn, s.sndUna) || !rudpBefore(sn, s.sndNxt) {
+		return
+	}
+	for i, seg := range s.sndBuf {
+		if seg.sn == sn {
+			s.sndBuf = append(s.sndBuf[:i], s.sndBuf[i+1:]...)
+			break
+		}
+		if rudpBefore(sn, seg.sn) {
+			break
+		}
+	}
+	s.shrinkBuf()
+}
+
+//比 maxAck 早发送但还没有确认的分段，可能已经丢失
+func (s *BaseRUDPSession) parseFastAck(maxAck uint32) {
+	for _, seg := range s.sndBuf {
+		if !rudpBefore(seg.sn, maxAck) {
+			break
+		}
+		seg.fastack++
+	}
+}
+
+func (s *BaseRUDPSession) shrinkBuf() {
+	if len(s.sndBuf) > 0 {
+		s.sndUna = s.sndBuf[0].sn
+	} else {
+		s.sndUna = s.sndNxt
+	}
+}
+
+//RFC 6298
+func (s *BaseRUDPSession) updateRTT(rtt int64) {
+	if s.srtt == 0 {
+		s.srtt = rtt
+		s.rttvar = rtt / 2
+	} else {
+		delta := rtt - s.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	if s.srtt < 1 {
+		s.srtt = 1
+	}
+	variance := 4 * s.rttvar
+	if interval := s.config.Interval.Milliseconds(); variance < interval {
+		variance = interval
+	}
+	rto := s.srtt + variance
+	if minRTO := s.config.MinRTO.Milliseconds(); rto < minRTO {
+		rto = minRTO
+	}
+	if rto > rudpMaxRTO {
+		rto = rudpMaxRTO
+	}
+	s.rto = uint32(rto)
+}
+
+//慢启动阶段每次确认推进加一，之后每个窗口加一
+func (s *BaseRUDPSession) growCwnd() {
+	if s.cwnd >= uint32(s.config.SndWnd) {
+		return
+	}
+	if s.cwnd < s.ssthresh {
+		s.cwnd++
+		return
+	}
+	s.incr++
+	if s.incr >= s.cwnd {
+		s.incr = 0
+		s.cwnd++
+	}
+}
+
+func (s *BaseRUDPSession) rcvWndUnused() uint16 {
+	wnd := s.config.RcvWnd - len(s.rcvSeen)
+	if wnd < 0 {
+		wnd = 0
+	}
+	if wnd > 0xffff {
+		wnd = 0xffff
+	}
+	return uint16(wnd)
+}
+
+func (s *BaseRUDPSession) flush() {
+	if s.closed.Get() != SOCKET_OPEN {
+		return
+	}
+	s.mu.Lock()
+	datagrams, dead := s.flushLocked(s.endpoint.now())
+	s.mu.Unlock()
+	s.endpoint.send(datagrams, s.addr)
+	if dead {
+		s.log().Log(LOG_LEVEL_WARN, "rudp session dead link", "remote", s.addr, "rto", s.rto)
+		s.closeState.record("write", ErrRUDPDeadLink)
+		s.Close()
+	}
+}
+
+func (s *BaseRUDPSession) flushLocked(now uint32) ([][]byte, bool) {
+	w := &rudpWriter{mtu: s.config.MTU}
+	wnd := s.rcvWndUnused()
+
+	for _, ack := range s.acklist {
+		seg := rudpSegment{conv: s.conv, cmd: rudpCmdAck, wnd: wnd, ts: ack.ts, sn: ack.sn, una: s.rcvNxt}
+		w.add(&seg)
+	}
+	s.acklist = s.acklist[:0]
+
+	limit := uint32(s.config.SndWnd)
+	if s.rmtWnd < limit {
+		limit = s.rmtWnd
+	}
+	if !s.config.NoCongestion && s.cwnd < limit {
+		limit = s.cwnd
+	}
+	for len(s.sndQueue) > 0 && rudpBefore(s.sndNxt, s.sndUna+limit) {
+		seg := s.sndQueue[0]
+		s.sndQueue[0] = nil
+		s.sndQueue = s.sndQueue[1:]
+		seg.conv = s.conv
+		seg.sn = s.sndNxt
+		s.sndNxt++
+		s.sndBuf = append(s.sndBuf, seg)
+	}
+
+	lost, change, dead, sent := false, false, false, false
+	for _, seg := range s.sndBuf {
+		resend := false
+		switch {
+		case seg.xmit == 0:
+			resend = true
+			seg.rto = s.rto
+			seg.resendts = now + seg.rto
+		case !rudpBefore(now, seg.resendts):
+			resend = true
+			lost = true
+			s.retransmits++
+			seg.rto += seg.rto / 2
+			if seg.rto > rudpMaxRTO {
+				seg.rto = rudpMaxRTO
+			}
+			seg.resendts = now + seg.rto
+		case s.config.FastResend > 0 && seg.fastack >= s.config.FastResend:
+			resend = true
+			change = true
+			s.fastRetransmits++
+			seg.fastack = 0
+			seg.resendts = now + seg.rto
+		}
+		if resend {
+			seg.xmit++
+			seg.ts = now
+			seg.wnd = wnd
+			seg.una = s.rcvNxt
+			w.add(seg)
+			sent = true
+			if seg.xmit > s.config.DeadLink {
+				dead = true
+			}
+		}
+	}
+
+	//对端窗口为 0、对端在探测窗口或者空闲太久时发送 ping
+	var keepalive uint32
+	if s.config.DeadLine > 0 {
+		keepalive = uint32(s.config.DeadLine * time.Second / 4 / time.Millisecond)
+	}
+	probe := s.rmtWnd == 0 && len(s.sndQueue) > 0 && now-s.lastSend >= s.rto
+	if !sent && len(w.buf) == 0 && len(w.out) == 0 && (probe || s.pingReply || (keepalive > 0 && now-s.lastSend >= keepalive)) {
+		seg := rudpSegment{conv: s.conv, cmd: rudpCmdPing, wnd: wnd, una: s.rcvNxt}
+		w.add(&seg)
+		sent = true
+	}
+	s.pingReply = false
+	if sent {
+		s.lastSend = now
+	}
+
+	if !s.config.NoCongestion {
+		if change {
+			inflight := s.sndNxt - s.sndUna
+			s.ssthresh = inflight / 2
+			if s.ssthresh < 2 {
+				s.ssthresh = 2
+			}
+			s.cwnd = s.ssthresh + uint32(s.config.FastResend)
+			s.incr = 0
+		}
+		if lost {
+			s.ssthresh = s.cwnd / 2
+			if s.ssthresh < 2 {
+				s.ssthresh = 2
+			}
+			s.cwnd = 1
+			s.incr = 0
+		}
+	}
+	return w.finish(), dead
+}
+
+//定时调用，检查空闲超时并重传
+func (s *BaseRUDPSession) update() {
+	s.mu.Lock()
+	idle := time.Since(s.lastRecv)
+	s.mu.Unlock()
+	if s.config.DeadLine > 0 && idle > s.config.DeadLine*time.Second {
+		s.closeState.record("read", errRUDPIdleTimeout)
+		s.Close()
+		return
+	}
+	s.flush()
+}
+
+/// 一个 UDP socket 上的所有会话
+type rudpEndpoint struct {
+	BaseUDPServerHandle
+	udp      BaseUDPStream
+	config   RUDPConfig
+	logger   Logger
+	accept   func(addr *net.UDPAddr, conv uint32) *BaseRUDPSession //为 nil 时不接受新会话
+	onError  func(err error)
+	epoch    time.Time
+	mu       sync.Mutex
+	sessions map[string]*BaseRUDPSession
+	quit     chan struct{}
+	once     sync.Once
+}
+
+func newRUDPEndpoint(config RUDPConfig, logger Logger) *rudpEndpoint {
+	e := &rudpEndpoint{
+		config:   config.withDefaults(),
+		logger:   logger,
+		epoch:    time.Now(),
+		sessions: make(map[string]*BaseRUDPSession),
+		quit:     make(chan struct{}),
+	}
+	e.udp.IBaseUDPStreamHandle = e
+	e.udp.Logger = logger
+	e.udp.ReadBufferSize = UDP_MAX_DATAGRAM_SIZE
+	e.udp.ReuseReadBuffer = true
+	return e
+}
+
+func (e *rudpEndpoint) start(addr string) error {
+	if err := e.udp.StartByAddr(addr); err != nil {
+		return err
+	}
+	go e.updateLoop()
+	return nil
+}
+
+//unit: millisecond
+func (e *rudpEndpoint) now() uint32 {
+	return uint32(time.Since(e.epoch).Milliseconds())
+}
+
+func (e *rudpEndpoint) send(datagrams [][]byte, addr *net.UDPAddr) {
+	conn, ok := e.udp.Conn.(*net.UDPConn)
+	if !ok {
+		return
+	}
+	for _, d := range datagrams {
+		if _, err := conn.WriteToUDP(d, addr); err != nil {
+			loggerOrNop(e.logger).Log(LOG_LEVEL_DEBUG, "rudp write failed", "remote", addr, "size", len(d), "err", err)
+		}
+	}
+}
+
+//会话数达到 maxSessions 时不添加，返回 false；maxSessions 小于等于 0 不限制
+func (e *rudpEndpoint) add(s *BaseRUDPSession, maxSessions int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if maxSessions > 0 && len(e.sessions) >= maxSessions {
+		return false
+	}
+	e.sessions[s.addr.String()] = s
+	return true
+}
+
+func (e *rudpEndpoint) remove(s *BaseRUDPSession) {
+	e.mu.Lock()
+	if e.sessions[s.addr.String()] == s {
+		delete(e.sessions, s.addr.String())
+	}
+	e.mu.Unlock()
+}
+
+func (e *rudpEndpoint) snapshot() []*BaseRUDPSession {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sessions := make([]*BaseRUDPSession, 0, len(e.sessions))
+	for _, s := range e.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (e *rudpEndpoint) OnRead(data []byte, addr *net.UDPAddr) {
+	seg, _, ok := decodeRUDPSegment(data)
+	if !ok {
+		return
+	}
+	key := addr.String()
+	e.mu.Lock()
+	s := e.sessions[key]
+	e.mu.Unlock()
+
+	if s != nil && s.conv != seg.conv {
+		//对端重启后以新的 conv 重新开始
+		if e.accept == nil || seg.cmd != rudpCmdPush || seg.sn != 0 {
+			return
+		}
+		s.closeState.record("read", io.EOF)
+		s.Close()
+		s = nil
+	}
+	if s == nil {
+		if e.accept == nil || (seg.cmd != rudpCmdPush && seg.cmd != rudpCmdPing) {
+			return
+		}
+		if s = e.accept(&net.UDPAddr{IP: append(net.IP(nil), addr.IP...), Port: addr.Port, Zone: addr.Zone}, seg.conv); s == nil {
+			return
+		}
+	}
+	s.input(data)
+}
+
+func (e *rudpEndpoint) OnException(err error) {
+	if IsOverflowError(err) {
+		return
+	}
+	if e.udp.closed.Get() == SOCKET_OPEN && e.onError != nil {
+		e.onError(err)
+	}
+}
+
+func (e *rudpEndpoint) updateLoop() {
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, s := range e.snapshot() {
+				s.update()
+			}
+		case <-e.quit:
+			return
+		}
+	}
+}
+
+//关闭所有会话和 socket
+func (e *rudpEndpoint) close() {
+	e.once.Do(func() {
+		close(e.quit)
+		for _, s := range e.snapshot() {
+			s.Close()
+		}
+		e.udp.Close()
+	})
+}
+
+/// RUDP Server
+type IBaseRUDPServerHandle interface {
+	IBaseStreamHandle
+	OnStart()
+	OnAccept(s *BaseRUDPSession) //在这里设置 s.IBaseRUDPSessionHandle
+}
+
+type BaseRUDPServerHandle struct {
+}
+
+func (h *BaseRUDPServerHandle) OnStart() {
+}
+
+func (h *BaseRUDPServerHandle) OnAccept(s *BaseRUDPSession) {
+}
+
+func (h *BaseRUDPServerHandle) OnClose() {
+}
+
+func (h *BaseRUDPServerHandle) OnException(err error) {
+}
+
+type BaseRUDPServer struct {
+	IBaseRUDPServerHandle
+	Config      RUDPConfig
+	Logger      Logger
+	MaxSessions int //会话数上限，达到后丢弃新远端的分段，0 时为 DEFAULT_RUDP_MAX_SESSIONS，小于 0 不限制
+	endpoint    *rudpEndpoint
+}
+
+//addr: "0.0.0.0:9000"、"[::]:9000"
+func (s *BaseRUDPServer) StartByAddr(addr string) error {
+	e := newRUDPEndpoint(s.Config, s.Logger)
+	e.accept = s.accept
+	e.onError = func(err error) {
+		if s.IBaseRUDPServerHandle != nil {
+			s.IBaseRUDPServerHandle.OnException(err)
+		}
+	}
+	s.endpoint = e
+	if err := e.start(addr); err != nil {
+		s.endpoint = nil
+		return err
+	}
+	if s.IBaseRUDPServerHandle != nil {
+		s.IBaseRUDPServerHandle.OnStart()
+	}
+	return nil
+}
+
+func (s *BaseRUDPServer) Start(ip string, port int32) error {
+	return s.StartByAddr(net.JoinHostPort(ip, fmt.Sprint(port)))
+}
+
+func (s *BaseRUDPServer) LocalUDPAddr() *net.UDPAddr {
+	if s.endpoint == nil {
+		return nil
+	}
+	return s.endpoint.udp.LocalUDPAddr()
+}
+
+func (s *BaseRUDPServer) SessionCount() int {
+	if s.endpoint == nil {
+		return 0
+	}
+	s.endpoint.mu.Lock()
+	defer s.endpoint.mu.Unlock()
+	return len(s.endpoint.sessions)
+}
+
+func (s *BaseRUDPServer) accept(addr *net.UDPAddr, conv uint32) *BaseRUDPSession {
+	maxSessions := s.MaxSessions
+	if maxSessions == 0 {
+		maxSessions = DEFAULT_RUDP_MAX_SESSIONS
+	}
+	session := &BaseRUDPSession{}
+	session.init(s.endpoint, addr, conv)
+	if !s.endpoint.add(session, maxSessions) {
+		loggerOrNop(s.Logger).Log(LOG_LEVEL_DEBUG, "rudp session limit reached, discard segment", "from", addr, "max", maxSessions)
+		if s.IBaseRUDPServerHandle != nil {
+			s.IBaseRUDPServerHandle.OnException(&StreamError{Kind: ERR_KIND_OVERFLOW, Op: "accept", Err: ErrUDPSessionLimit})
+		}
+		return nil
+	}
+	loggerOrNop(s.Logger).Log(LOG_LEVEL_DEBUG, "rudp session accepted", "remote", addr, "conv", conv)
+	if s.IBaseRUDPServerHandle != nil {
+		s.IBaseRUDPServerHandle.OnAccept(session)
+	}
+	return session
+}
+
+func (s *BaseRUDPServer) Close() {
+	if s.endpoint != nil {
+		s.endpoint.close()
+		s.endpoint = nil
+		if s.IBaseRUDPServerHandle != nil {
+			s.IBaseRUDPServerHandle.OnClose()
+		}
+	}
+}
+
+/// RUDP Client
+//独占一个本地 UDP socket，Close 时一起关闭
+type BaseRUDPClient struct {
+	BaseRUDPSession
+	Config RUDPConfig
+}
+
+//addr: "127.0.0.1:9000"、"[::1]:9000"，UDP 没有握手，对端在收到第一个分段时建立会话
+func (c *BaseRUDPClient) ConnectByAddr(addr string) error {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	local := "0.0.0.0:0"
+	if raddr.IP.To4() == nil {
+		local = "[::]:0"
+	}
+	e := newRUDPEndpoint(c.Config, c.Logger)
+	e.onError = func(err error) {
+		c.BaseRUDPSession.CloseWithError(err)
+	}
+	if err = e.start(local); err != nil {
+		return err
+	}
+	c.BaseRUDPSession.init(e, raddr, rand.Uint32())
+	c.onClose = e.close
+	e.add(&c.BaseRUDPSession, 0)
+	return nil
+}
@@ -0,0 +1,261 @@
+// reliable_udp_test.go
+package gobase
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+//在 client 和 server 之间转发数据报，按 loss 概率丢包
+func startLossyRelay(t *testing.T, server *net.UDPAddr, loss float64) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	rnd := rand.New(rand.NewSource(1))
+	var mu sync.Mutex
+	var client *net.UDPAddr
+	go func() {
+		buf := make([]byte, UDP_MAX_DATAGRAM_SIZE)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			drop := rnd.Float64() < loss
+			dst := server
+			if addr.Port == server.Port {
+				dst = client
+			} else {
+				client = addr
+			}
+			mu.Unlock()
+			if !drop && dst != nil {
+				conn.WriteToUDP(buf[:n], dst)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+type rudpRecordHandle struct {
+	BaseRUDPSessionHandle
+	mu       sync.Mutex
+	messages [][]byte
+	channels []uint8
+	received chan struct{}
+	closed   chan CloseReason
+}
+
+func newRUDPRecordHandle() *rudpRecordHandle {
+	return &rudpRecordHandle{received: make(chan struct{}, 10000), closed: make(chan CloseReason, 1)}
+}
+
+func (h *rudpRecordHandle) OnReadChannel(channel uint8, data []byte) {
+	h.mu.Lock()
+	h.messages = append(h.messages, data)
+	h.channels = append(h.channels, channel)
+	h.mu.Unlock()
+	h.received <- struct{}{}
+}
+
+func (h *rudpRecordHandle) OnCloseWithReason(reason CloseReason) {
+	h.closed <- reason
+}
+
+func (h *rudpRecordHandle) waitMessages(t *testing.T, n int) [][]byte {
+	deadline := time.After(10 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-h.received:
+		case <-deadline:
+			t.Fatalf("received %d of %d messages", i, n)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([][]byte(nil), h.messages...)
+}
+
+type rudpServerHandle struct {
+	BaseRUDPServerHandle
+	sessions chan *rudpRecordHandle
+}
+
+func (h *rudpServerHandle) OnAccept(s *BaseRUDPSession) {
+	handle := newRUDPRecordHandle()
+	s.IBaseRUDPSessionHandle = handle
+	h.sessions <- handle
+}
+
+func startRUDPServer(t *testing.T, config RUDPConfig) (*BaseRUDPServer, *rudpServerHandle) {
+	h := &rudpServerHandle{sessions: make(chan *rudpRecordHandle, 4)}
+	s := &BaseRUDPServer{IBaseRUDPServerHandle: h, Config: config}
+	if err := s.Start("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, h
+}
+
+func rudpMessage(i int) []byte {
+	//每 10 条里有一条需要分片
+	size := 16 + i%7*50
+	if i%10 == 0 {
+		size = 5000
+	}
+	msg := bytes.Repeat([]byte{byte(i)}, size)
+	msg[0] = byte(i >> 8)
+	return msg
+}
+
+func Test_RUDPOrderedOverLossyLink(t *testing.T) {
+	config := RUDPConfig{Interval: 5 * time.Millisecond, MinRTO: 20 * time.Millisecond}
+	s, sh := startRUDPServer(t, config)
+	relay := startLossyRelay(t, s.LocalUDPAddr(), 0.2)
+
+	c := &BaseRUDPClient{Config: config}
+	c.IBaseRUDPSessionHandle = newRUDPRecordHandle()
+	if err := c.ConnectByAddr(relay.String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const count = 200
+	for i := 0; i < count; i++ {
+		if err := c.Write(rudpMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var server *rudpRecordHandle
+	select {
+	case server = <-sh.sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not accepted")
+	}
+	messages := server.waitMessages(t, count)
+	for i, msg := range messages {
+		if !bytes.Equal(msg, rudpMessage(i)) {
+			t.Fatalf("message %d out of order or corrupted, size %d", i, len(msg))
+		}
+	}
+	if stats := c.Stats(); stats.Retransmits+stats.FastRetransmits == 0 || stats.SRTT <= 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func Test_RUDPUnorderedChannel(t *testing.T) {
+	config := RUDPConfig{UnorderedChannels: []uint8{1}}
+	s, sh := startRUDPServer(t, config)
+
+	c := &BaseRUDPClient{Config: config}
+	c.IBaseRUDPSessionHandle = newRUDPRecordHandle()
+	if err := c.ConnectByAddr(s.LocalUDPAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.WriteChannel(1, make([]byte, 5000)); !errors.Is(err, ErrRUDPMessageTooLarge) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	c.WriteChannel(1, []byte("state"))
+	server := <-sh.sessions
+	server.waitMessages(t, 1)
+	if server.channels[0] != 1 || string(server.messages[0]) != "state" {
+		t.Fatalf("unexpected message %q on channel %d", server.messages[0], server.channels[0])
+	}
+}
+
+func Test_RUDPClose(t *testing.T) {
+	s, sh := startRUDPServer(t, RUDPConfig{})
+	c := &BaseRUDPClient{}
+	c.IBaseRUDPSessionHandle = newRUDPRecordHandle()
+	if err := c.ConnectByAddr(s.LocalUDPAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	c.WriteString("hello")
+	server := <-sh.sessions
+	server.waitMessages(t, 1)
+	c.Close()
+	select {
+	case reason := <-server.closed:
+		if reason.Initiator != CLOSE_BY_PEER {
+			t.Fatalf("unexpected close reason %s", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server session not closed")
+	}
+}
+
+func Test_RUDPDeadLink(t *testing.T) {
+	dead, _ := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	addr := dead.LocalAddr().String()
+	dead.Close()
+
+	h := newRUDPRecordHandle()
+	c := &BaseRUDPClient{Config: RUDPConfig{DeadLink: 3, MinRTO: 10 * time.Millisecond}}
+	c.IBaseRUDPSessionHandle = h
+	if err := c.ConnectByAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	c.WriteString("anyone?")
+	select {
+	case reason := <-h.closed:
+		if !errors.Is(reason.Err, ErrRUDPDeadLink) || reason.Kind != ERR_KIND_TIMEOUT {
+			t.Fatalf("unexpected close reason %s", reason)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("dead link not detected")
+	}
+}
+
+func Test_RUDPSequenceWrap(t *testing.T) {
+	if !rudpBefore(0xfffffff0, 5) || rudpBefore(5, 0xfffffff0) {
+		t.Fatal("sequence comparison should handle wrap around")
+	}
+}
+
+func Test_RUDPReceiveBoundsChannelGap(t *testing.T) {
+	e := newRUDPEndpoint(RUDPConfig{RcvWnd: 8}, nil)
+	s := &BaseRUDPSession{}
+	s.init(e, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, 1)
+	//csn 永远补不上缺口时，每个 channel 最多缓存一个接收窗口
+	for csn := uint32(1); csn < 1000; csn++ {
+		s.receive(&rudpSegment{channel: 3, csn: csn, data: []byte{1}}, nil)
+	}
+	if pending := len(s.channels[3].pending); pending != 7 {
+		t.Fatalf("expect 7 pending segments, got %d", pending)
+	}
+	if out := s.receive(&rudpSegment{channel: 3, csn: 0, data: []byte{0}}, nil); len(out) != 8 {
+		t.Fatalf("expect 8 deliveries after the gap is closed, got %d", len(out))
+	}
+}
+
+func Test_RUDPServerMaxSessions(t *testing.T) {
+	s, _ := startRUDPServer(t, RUDPConfig{})
+	s.MaxSessions = 1
+	for i := 0; i < 3; i++ {
+		conn, err := net.DialUDP("udp4", nil, s.LocalUDPAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		seg := rudpSegment{conv: uint32(i + 1), cmd: rudpCmdPush, data: []byte("hello")}
+		conn.Write(seg.encode(nil))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.SessionCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := s.SessionCount(); n != 1 {
+		t.Fatalf("expect 1 session, got %d", n)
+	}
+}
@@ -8,7 +8,8 @@ import (
 type SignalHandler func(s os.Signal, arg interface{})
 
 type SignalSet struct {
-	m map[os.Signal]SignalHandler
+	m      map[os.Signal]SignalHandler
+	logger Logger
 }
 
 func SignalSetNew() *SignalSet {
@@ -17,17 +18,24 @@ func SignalSetNew() *SignalSet {
 	return ss
 }
 
+func (set *SignalSet) SetLogger(logger Logger) {
+	set.logger = logger
+}
+
 func (set *SignalSet) Register(s os.Signal, handler SignalHandler) {
 	if _, found := set.m[s]; !found {
 		set.m[s] = handler
+		loggerOrNop(set.logger).Log(LOG_LEVEL_DEBUG, "signal handler registered", "signal", s)
 	}
 }
 
 func (set *SignalSet) Handle(sig os.Signal, arg interface{}) (err error) {
 	if _, found := set.m[sig]; found {
+		loggerOrNop(set.logger).Log(LOG_LEVEL_INFO, "signal received", "signal", sig)
 		set.m[sig](sig, arg)
 		return nil
 	} else {
+		loggerOrNop(set.logger).Log(LOG_LEVEL_WARN, "no handler available for signal", "signal", sig)
 		return fmt.Errorf("No handler available for signal %v", sig)
 	}
 	panic("won't reach here")
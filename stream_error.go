@@ -0,0 +1,213 @@
+// stream_error.go
+package gobase
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+type ErrorKind int
+
+const (
+	ERR_KIND_UNKNOWN ErrorKind = iota
+	ERR_KIND_TIMEOUT
+	ERR_KIND_EOF
+	ERR_KIND_RESET
+	ERR_KIND_LOCAL_CLOSE
+	ERR_KIND_OVERFLOW
+	ERR_KIND_PROTOCOL
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ERR_KIND_TIMEOUT:
+		return "timeout"
+	case ERR_KIND_EOF:
+		return "eof"
+	case ERR_KIND_RESET:
+		return "reset"
+	case ERR_KIND_LOCAL_CLOSE:
+		return "local close"
+	case ERR_KIND_OVERFLOW:
+		return "overflow"
+	case ERR_KIND_PROTOCOL:
+		return "protocol error"
+	}
+	return "unknown"
+}
+
+var ErrWriteOverflow = errors.New("write chan overflow, discard data")
+var ErrLocalClose = errors.New("closed by local")
+
+//OnException 收到的错误都是 *StreamError，Op 为 "read"、"write"、"flush" 等，
+//Err 为底层原始错误，可以继续用 errors.Is 判断
+type StreamError struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+func (e *StreamError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StreamError) Timeout() bool {
+	return e.Kind == ERR_KIND_TIMEOUT
+}
+
+func (e *StreamError) Temporary() bool {
+	return e.Kind == ERR_KIND_TIMEOUT || e.Kind == ERR_KIND_OVERFLOW
+}
+
+func NewStreamError(op string, err error) *StreamError {
+	if se, ok := err.(*StreamError); ok {
+		return se
+	}
+	return &StreamError{Kind: ClassifyError(err), Op: op, Err: err}
+}
+
+//应用层解析失败时使用，配合 CloseWithError 关闭连接
+func NewProtocolError(err error) *StreamError {
+	return &StreamError{Kind: ERR_KIND_PROTOCOL, Op: "protocol", Err: err}
+}
+
+func ClassifyError(err error) ErrorKind {
+	if err == nil {
+		return ERR_KIND_UNKNOWN
+	}
+	var se *StreamError
+	if errors.As(err, &se) {
+		return se.Kind
+	}
+	switch {
+	case errors.Is(err, ErrWriteOverflow):
+		return ERR_KIND_OVERFLOW
+	case errors.Is(err, ErrLocalClose), errors.Is(err, net.ErrClosed):
+		return ERR_KIND_LOCAL_CLOSE
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ERR_KIND_EOF
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNABORTED):
+		return ERR_KIND_RESET
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ERR_KIND_TIMEOUT
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ERR_KIND_TIMEOUT
+	}
+	return ERR_KIND_UNKNOWN
+}
+
+func IsTimeoutError(err error) bool {
+	return ClassifyError(err) == ERR_KIND_TIMEOUT
+}
+
+func IsEOFError(err error) bool {
+	return ClassifyError(err) == ERR_KIND_EOF
+}
+
+func IsResetError(err error) bool {
+	return ClassifyError(err) == ERR_KIND_RESET
+}
+
+func IsLocalCloseError(err error) bool {
+	return ClassifyError(err) == ERR_KIND_LOCAL_CLOSE
+}
+
+func IsOverflowError(err error) bool {
+	return ClassifyError(err) == ERR_KIND_OVERFLOW
+}
+
+func IsProtocolError(err error) bool {
+	return ClassifyError(err) == ERR_KIND_PROTOCOL
+}
+
+type CloseInitiator int
+
+const (
+	CLOSE_BY_LOCAL CloseInitiator = iota
+	CLOSE_BY_PEER
+)
+
+func (i CloseInitiator) String() string {
+	if i == CLOSE_BY_PEER {
+		return "peer"
+	}
+	return "local"
+}
+
+type CloseReason struct {
+	Kind      ErrorKind
+	Initiator CloseInitiator
+	Err       error
+}
+
+func (r CloseReason) String() string {
+	if r.Err == nil {
+		return r.Kind.String() + " by " + r.Initiator.String()
+	}
+	return r.Kind.String() + " by " + r.Initiator.String() + ", err: " + r.Err.Error()
+}
+
+//handle 实现该接口时 Close 回调 OnCloseWithReason，不再回调 OnClose
+type IBaseStreamCloseReasonHandle interface {
+	OnCloseWithReason(reason CloseReason)
+}
+
+//记录第一个导致连接不可用的错误，作为关闭原因
+type streamCloseState struct {
+	mu  sync.Mutex
+	err *StreamError
+}
+
+func (s *streamCloseState) reset() {
+	s.mu.Lock()
+	s.err = nil
+	s.mu.Unlock()
+}
+
+func (s *streamCloseState) record(op string, err error) *StreamError {
+	se := NewStreamError(op, err)
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = se
+	}
+	s.mu.Unlock()
+	return se
+}
+
+func (s *streamCloseState) reason() CloseReason {
+	s.mu.Lock()
+	se := s.err
+	s.mu.Unlock()
+	if se == nil {
+		return CloseReason{Kind: ERR_KIND_LOCAL_CLOSE, Initiator: CLOSE_BY_LOCAL}
+	}
+	reason := CloseReason{Kind: se.Kind, Initiator: CLOSE_BY_LOCAL, Err: se}
+	if se.Kind == ERR_KIND_EOF || se.Kind == ERR_KIND_RESET {
+		reason.Initiator = CLOSE_BY_PEER
+	}
+	return reason
+}
+
+func notifyClose(handle IBaseStreamHandle, reason CloseReason) {
+	if handle == nil {
+		return
+	}
+	if h, ok := handle.(IBaseStreamCloseReasonHandle); ok {
+		h.OnCloseWithReason(reason)
+	} else {
+		handle.OnClose()
+	}
+}
@@ -0,0 +1,138 @@
+// stream_error_test.go
+package gobase
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{io.EOF, ERR_KIND_EOF},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ERR_KIND_RESET},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, ERR_KIND_TIMEOUT},
+		{net.ErrClosed, ERR_KIND_LOCAL_CLOSE},
+		{NewStreamError("write", ErrWriteOverflow), ERR_KIND_OVERFLOW},
+		{NewProtocolError(errors.New("bad header")), ERR_KIND_PROTOCOL},
+		{errors.New("other"), ERR_KIND_UNKNOWN},
+	}
+	for _, c := range cases {
+		if kind := ClassifyError(c.err); kind != c.kind {
+			t.Errorf("ClassifyError(%v) = %s, want %s", c.err, kind, c.kind)
+		}
+	}
+	if !errors.Is(NewStreamError("read", io.EOF), io.EOF) {
+		t.Error("StreamError should unwrap to the original error")
+	}
+}
+
+type closeReasonSessionHandle struct {
+	BaseTCPSessionHandle
+	session *BaseTCPSession
+	reasons chan CloseReason
+}
+
+func (h *closeReasonSessionHandle) OnException(err error) {
+	h.session.Close()
+}
+
+func (h *closeReasonSessionHandle) OnCloseWithReason(reason CloseReason) {
+	h.reasons <- reason
+}
+
+type closeReasonServerHandle struct {
+	BaseTCPServerHandle
+	sessions chan *BaseTCPSession
+	reasons  chan CloseReason
+}
+
+func (h *closeReasonServerHandle) OnAccept(c net.Conn) {
+	session := &BaseTCPSession{}
+	session.Conn = c
+	session.IBaseTCPStreamHandle = &closeReasonSessionHandle{session: session, reasons: h.reasons}
+	session.Start()
+	h.sessions <- session
+}
+
+func Test_TCPCloseReason(t *testing.T) {
+	handle := &closeReasonServerHandle{sessions: make(chan *BaseTCPSession, 2), reasons: make(chan CloseReason, 2)}
+	s := &BaseTCPServer{IBaseTCPServerHandle: handle}
+	if err := s.StartByAddr("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	//peer close
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-handle.sessions
+	conn.Close()
+	select {
+	case reason := <-handle.reasons:
+		if reason.Kind != ERR_KIND_EOF || reason.Initiator != CLOSE_BY_PEER {
+			t.Fatalf("unexpected reason: %s", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait close reason timeout")
+	}
+
+	//local close with protocol error
+	conn, err = net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	session := <-handle.sessions
+	session.CloseWithError(NewProtocolError(errors.New("bad header")))
+	select {
+	case reason := <-handle.reasons:
+		if reason.Kind != ERR_KIND_PROTOCOL || reason.Initiator != CLOSE_BY_LOCAL {
+			t.Fatalf("unexpected reason: %s", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait close reason timeout")
+	}
+}
+
+func Test_TCPWriteOverflowIsNotCloseReason(t *testing.T) {
+	handle := &closeReasonServerHandle{sessions: make(chan *BaseTCPSession, 1), reasons: make(chan CloseReason, 1)}
+	s := &BaseTCPServer{IBaseTCPServerHandle: handle}
+	if err := s.StartByAddr("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := <-handle.sessions
+	//对方不读取，写满发送缓冲区和 write chan
+	data := make([]byte, 64*1024)
+	overflowed := false
+	for i := 0; i < 100000 && !overflowed; i++ {
+		overflowed = errors.Is(session.Write(data), ErrWriteOverflow)
+	}
+	if !overflowed {
+		t.Fatal("write chan not overflowed")
+	}
+	conn.Close()
+	select {
+	case reason := <-handle.reasons:
+		if reason.Kind == ERR_KIND_OVERFLOW || reason.Initiator != CLOSE_BY_PEER {
+			t.Fatalf("unexpected reason: %s", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait close reason timeout")
+	}
+}
@@ -0,0 +1,283 @@
+// tcp_client_dial.go
+package gobase
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+const DEFAULT_FALLBACK_DELAY = 300 * time.Millisecond
+
+type DialStrategy int
+
+const (
+	DIAL_STRATEGY_FAILOVER       DialStrategy = iota //按顺序逐个尝试
+	DIAL_STRATEGY_HAPPY_EYEBALLS                     //IPv6/IPv4 交替，间隔 FallbackDelay 并发尝试，先连上者胜出
+)
+
+//*net.Resolver 实现了该接口，测试中可以替换为假的实现
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+var ErrNoEndpoint = errors.New("no endpoint available")
+var ErrLocalAddrWithDialer = errors.New("local address cannot be used with a custom dialer")
+
+//host 为域名时解析所有 A/AAAA 记录
+func (c *BaseTCPClient) ConnectHost(host string, port int32) error {
+	addr := net.JoinHostPort(host, strconv.FormatInt(int64(port), 10))
+	return c.ConnectEndpoints([]string{addr})
+}
+
+//endpoints: ["10.0.0.1:80", "backend.local:80"]
+func (c *BaseTCPClient) ConnectEndpoints(endpoints []string) error {
+	return c.ConnectEndpointsTimeOut(endpoints, DEFAULT_CONNECT_TIMEOUT, DEFAULT_DEADLINE)
+}
+
+//timeOut 为整个连接过程（含域名解析）的超时，unit: second
+func (c *BaseTCPClient) ConnectEndpointsTimeOut(endpoints []string, timeOut time.Duration, deadLine time.Duration) error {
+	c.endpoints = append([]string(nil), endpoints...)
+	c.connectTimeOut = timeOut
+	c.connectDeadLine = deadLine
+	return c.connectEndpoints()
+}
+
+//使用上一次 ConnectEndpoints 的参数重新解析并连接
+func (c *BaseTCPClient) Reconnect() error {
+	if len(c.endpoints) == 0 {
+		return c.ConnectByAddrTimeOut(c.RemoteAddress, DEFAULT_CONNECT_TIMEOUT, DEFAULT_DEADLINE)
+	}
+	return c.connectEndpoints()
+}
+
+//当前连接实际使用的 "ip:port"
+func (c *BaseTCPClient) SelectedEndpoint() string {
+	return c.selected.Get()
+}
+
+//timeOut 不大于 0 时不限制，和 net.DialTimeout 一致，unit: second
+func connectContext(timeOut time.Duration) (context.Context, context.CancelFunc) {
+	if timeOut <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeOut*time.Second)
+}
+
+func (c *BaseTCPClient) connectEndpoints() error {
+	c.closed.Set(SOCKET_CLOSED)
+	ctx, cancel := connectContext(c.connectTimeOut)
+	defer cancel()
+
+	addrs, err := c.resolveEndpoints(ctx, c.endpoints)
+	if err == nil {
+		var conn net.Conn
+		var addr string
+		if c.DialStrategy == DIAL_STRATEGY_HAPPY_EYEBALLS {
+			conn, addr, err = c.dialHappyEyeballs(ctx, addrs)
+		} else {
+			conn, addr, err = c.dialFailover(ctx, addrs)
+		}
+		if err == nil {
+			c.RemoteAddress = addr
+			c.selected.Set(addr)
+			c.startConn(conn, addr, c.connectDeadLine)
+			return nil
+		}
+	}
+	c.log().Log(LOG_LEVEL_WARN, "tcp client connect failed", "endpoints", c.endpoints, "err", err)
+	c.IBaseTCPStreamHandle.(IBaseTCPClientHandle).OnConnect(false)
+	return err
+}
+
+func (c *BaseTCPClient) resolver() Resolver {
+	if c.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return c.Resolver
+}
+
+//返回去重后的 "ip:port" 列表，保持 endpoints 的顺序
+func (c *BaseTCPClient) resolveEndpoints(ctx context.Context, endpoints []string) ([]string, error) {
+	var addrs []string
+	var firstErr error
+	seen := make(map[string]bool)
+	add := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, endpoint := range endpoints {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		//经过代理时域名交给代理解析
+		if _, err := netip.ParseAddr(host); err == nil || c.Dialer != nil {
+			add(net.JoinHostPort(host, port))
+			continue
+		}
+		ipAddrs, err := c.resolver().LookupIPAddr(ctx, host)
+		if err != nil {
+			c.log().Log(LOG_LEVEL_WARN, "tcp client resolve failed", "host", host, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, ipAddr := range ipAddrs {
+			add(net.JoinHostPort(ipAddr.String(), port))
+		}
+	}
+	if len(addrs) == 0 {
+		if firstErr == nil {
+			firstErr = ErrNoEndpoint
+		}
+		return nil, firstErr
+	}
+	return addrs, nil
+}
+
+func (c *BaseTCPClient) dialFailover(ctx context.Context, addrs []string) (net.Conn, string, error) {
+	var firstErr error
+	for i, addr := range addrs {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		//剩余时间平分给剩余的地址，和 net.Dialer 的做法一致
+		if deadline, ok := ctx.Deadline(); ok {
+			dialCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(addrs)-i))
+		}
+		conn, err := c.dialContext(dialCtx, addr)
+		cancel()
+		if err == nil {
+			return conn, addr, nil
+		}
+		c.log().Log(LOG_LEVEL_DEBUG, "tcp client dial failed, try next", "addr", addr, "err", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, "", firstErr
+}
+
+type dialResult struct {
+	conn net.Conn
+	addr string
+	err  error
+}
+
+func (c *BaseTCPClient) dialHappyEyeballs(ctx context.Context, addrs []string) (net.Conn, string, error) {
+	addrs = interleaveFamilies(addrs)
+	delay := c.FallbackDelay
+	if delay <= 0 {
+		delay = DEFAULT_FALLBACK_DELAY
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(addrs))
+	next, pending := 0, 0
+	var fallback <-chan time.Time
+	startNext := func() {
+		if next < len(addrs) {
+			go func(addr string) {
+				conn, err := c.dialContext(raceCtx, addr)
+				results <- dialResult{conn: conn, addr: addr, err: err}
+			}(addrs[next])
+			next++
+			pending++
+			fallback = time.After(delay)
+		}
+	}
+	//关闭输掉的尝试中已经建立的连接
+	discard := func(n int) {
+		for ; n > 0; n-- {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}
+	}
+
+	var firstErr error
+	startNext()
+	for {
+		select {
+		case <-fallback:
+			startNext()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go discard(pending)
+				return r.conn, r.addr, nil
+			}
+			c.log().Log(LOG_LEVEL_DEBUG, "tcp client dial failed", "addr", r.addr, "err", r.err)
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			//失败后立即启动下一个尝试
+			startNext()
+			if pending == 0 {
+				return nil, "", firstErr
+			}
+		case <-ctx.Done():
+			go discard(pending)
+			if firstErr == nil {
+				firstErr = ctx.Err()
+			}
+			return nil, "", firstErr
+		}
+	}
+}
+
+//按 RFC 8305 交替 IPv6 与 IPv4，以第一个地址的协议族开头
+func interleaveFamilies(addrs []string) []string {
+	var first, second []string
+	firstIsV4 := false
+	for i, addr := range addrs {
+		isV4 := false
+		if ap, err := netip.ParseAddrPort(addr); err == nil {
+			isV4 = ap.Addr().Unmap().Is4()
+		}
+		if i == 0 {
+			firstIsV4 = isV4
+		}
+		if isV4 == firstIsV4 {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+	res := make([]string, 0, len(addrs))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			res = append(res, first[0])
+			first = first[1:]
+		}
+		if len(second) > 0 {
+			res = append(res, second[0])
+			second = second[1:]
+		}
+	}
+	return res
+}
+
+func (c *BaseTCPClient) dialContext(ctx context.Context, addr string) (net.Conn, error) {
+	return c.breakerDialer(c.Dialer).DialContext(ctx, "tcp", addr)
+}
+
+func (c *BaseTCPClient) breakerDialer(d ContextDialer) ContextDialer {
+	if c.CircuitBreaker != nil {
+		return c.CircuitBreaker.WrapDialer(d)
+	}
+	return forwardDialer(d)
+}
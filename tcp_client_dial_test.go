@@ -0,0 +1,88 @@
+// tcp_client_dial_test.go
+package gobase
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+)
+
+type fakeResolver struct {
+	hosts   map[string][]net.IPAddr
+	lookups int
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups++
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func Test_TCPClientConnectEndpoints(t *testing.T) {
+	s := &BaseTCPServer{IBaseTCPServerHandle: &BaseTCPServerHandle{}}
+	if err := s.StartByAddr("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	port := s.Addr().(*net.TCPAddr).Port
+
+	//127.0.0.2 上没有监听，应当失败后切到 127.0.0.1
+	resolver := &fakeResolver{hosts: map[string][]net.IPAddr{
+		"backend.test": {{IP: net.ParseIP("127.0.0.2")}, {IP: net.ParseIP("127.0.0.1")}},
+	}}
+	for _, strategy := range []DialStrategy{DIAL_STRATEGY_FAILOVER, DIAL_STRATEGY_HAPPY_EYEBALLS} {
+		c := &BaseTCPClient{Resolver: resolver, DialStrategy: strategy}
+		c.IBaseTCPStreamHandle = &BaseTCPClientHandle{}
+		if err := c.ConnectHost("backend.test", int32(port)); err != nil {
+			t.Fatalf("strategy %d: %v", strategy, err)
+		}
+		want := "127.0.0.1:" + strconv.Itoa(port)
+		if c.SelectedEndpoint() != want {
+			t.Fatalf("strategy %d: selected %s, want %s", strategy, c.SelectedEndpoint(), want)
+		}
+		c.Close()
+
+		lookups := resolver.lookups
+		if err := c.Reconnect(); err != nil {
+			t.Fatal(err)
+		}
+		if resolver.lookups != lookups+1 {
+			t.Fatal("reconnect should resolve the host again")
+		}
+		c.Close()
+	}
+}
+
+func Test_InterleaveFamilies(t *testing.T) {
+	addrs := interleaveFamilies([]string{"[::1]:80", "[::2]:80", "127.0.0.1:80", "127.0.0.2:80"})
+	want := []string{"[::1]:80", "127.0.0.1:80", "[::2]:80", "127.0.0.2:80"}
+	for i := range want {
+		if addrs[i] != want[i] {
+			t.Fatalf("got %v, want %v", addrs, want)
+		}
+	}
+}
+
+func Test_TCPClientConnectZeroTimeOut(t *testing.T) {
+	s := &BaseTCPServer{IBaseTCPServerHandle: &BaseTCPServerHandle{}}
+	if err := s.StartByAddr("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	addr := s.Addr().String()
+
+	//0 表示不限制连接超时
+	c := &BaseTCPClient{}
+	c.IBaseTCPStreamHandle = &BaseTCPClientHandle{}
+	if err := c.ConnectByAddrTimeOut(addr, 0, DEFAULT_DEADLINE); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err := c.ConnectEndpointsTimeOut([]string{"127.0.0.2:1", addr}, 0, DEFAULT_DEADLINE); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
@@ -0,0 +1,408 @@
+// tcp_proxy.go
+package gobase
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_HEALTH_CHECK_INTERVAL = 5 //unit: second
+	DEFAULT_HEALTH_CHECK_TIMEOUT  = 2 //unit: second
+	DEFAULT_UPSTREAM_MAX_FAILS    = 1
+	DEFAULT_UPSTREAM_FAIL_TIMEOUT = 10 //unit: second
+	tcpProxyBufferSize            = 32 * 1024
+	consistentHashReplicas        = 160
+)
+
+var ErrNoHealthyUpstream = errors.New("no healthy upstream")
+
+var tcpProxyBufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, tcpProxyBufferSize)
+	},
+}
+
+type Upstream struct {
+	Addr          string
+	healthy       AtomicInt32 //主动健康检查的结果
+	fails         AtomicInt32 //连续连接失败次数
+	downUntil     AtomicInt64 //被动标记为不可用的截止时间，unix nano
+	activeConns   AtomicInt64
+	totalConns    AtomicInt64
+	failedConns   AtomicInt64
+	bytesSent     AtomicInt64 //client -> upstream
+	bytesReceived AtomicInt64 //upstream -> client
+}
+
+//主动健康检查通过，且不在连接失败后的暂停期内
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Get() == SOCKET_OPEN && time.Now().UnixNano() >= u.downUntil.Get()
+}
+
+func (u *Upstream) ActiveConns() int64 {
+	return u.activeConns.Get()
+}
+
+type UpstreamStats struct {
+	Addr          string
+	Healthy       bool
+	ActiveConns   int64
+	TotalConns    int64
+	FailedConns   int64
+	BytesSent     int64
+	BytesReceived int64
+}
+
+func (u *Upstream) Stats() UpstreamStats {
+	return UpstreamStats{
+		Addr:          u.Addr,
+		Healthy:       u.Healthy(),
+		ActiveConns:   u.activeConns.Get(),
+		TotalConns:    u.totalConns.Get(),
+		FailedConns:   u.failedConns.Get(),
+		BytesSent:     u.bytesSent.Get(),
+		BytesReceived: u.bytesReceived.Get(),
+	}
+}
+
+//Pick 从 upstreams 中选出一个健康的 upstream，没有时返回 nil
+type Balancer interface {
+	Pick(upstreams []*Upstream, client net.Addr) *Upstream
+}
+
+type RoundRobinBalancer struct {
+	next AtomicUint32
+}
+
+func (b *RoundRobinBalancer) Pick(upstreams []*Upstream, client net.Addr) *Upstream {
+	n := uint32(len(upstreams))
+	for i := uint32(0); i < n; i++ {
+		u := upstreams[(b.next.Add(1)-1)%n]
+		if u.Healthy() {
+			return u
+		}
+	}
+	return nil
+}
+
+type LeastConnBalancer struct {
+}
+
+func (b *LeastConnBalancer) Pick(upstreams []*Upstream, client net.Addr) *Upstream {
+	var res *Upstream
+	for _, u := range upstreams {
+		if u.Healthy() && (res == nil || u.ActiveConns() < res.ActiveConns()) {
+			res = u
+		}
+	}
+	return res
+}
+
+//按客户端 IP 做一致性哈希，upstream 下线时只影响落在它上面的客户端
+type ConsistentHashBalancer struct {
+	mu        sync.Mutex
+	upstreams []*Upstream
+	ring      []uint32
+	nodes     map[uint32]*Upstream
+}
+
+func (b *ConsistentHashBalancer) Pick(upstreams []*Upstream, client net.Addr) *Upstream {
+	b.mu.Lock()
+	if !sameUpstreams(b.upstreams, upstreams) {
+		b.build(upstreams)
+	}
+	ring, nodes := b.ring, b.nodes
+	b.mu.Unlock()
+	if len(ring) == 0 {
+		return nil
+	}
+
+	key := client.String()
+	if host, _, err := net.SplitHostPort(key); err == nil {
+		key = host
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ring), func(i int) bool { return ring[i] >= h })
+	for n := 0; n < len(ring); n++ {
+		if u := nodes[ring[(i+n)%len(ring)]]; u.Healthy() {
+			return u
+		}
+	}
+	return nil
+}
+
+func (b *ConsistentHashBalancer) build(upstreams []*Upstream) {
+	b.upstreams = upstreams
+	b.ring = make([]uint32, 0, len(upstreams)*consistentHashReplicas)
+	b.nodes = make(map[uint32]*Upstream, len(upstreams)*consistentHashReplicas)
+	for _, u := range upstreams {
+		for i := 0; i < consistentHashReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(u.Addr + "#" + strconv.Itoa(i)))
+			if _, ok := b.nodes[h]; !ok {
+				b.nodes[h] = u
+				b.ring = append(b.ring, h)
+			}
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i] < b.ring[j] })
+}
+
+func sameUpstreams(a, b []*Upstream) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//TCP 四层转发，在 BaseTCPServer 上接收连接，按 Balancer 选择 upstream 后双向转发
+type TCPProxy struct {
+	BaseTCPServerHandle
+	server              BaseTCPServer
+	upstreams           []*Upstream
+	Balancer            Balancer      //为 nil 时使用 RoundRobinBalancer
+	Dialer              ContextDialer //连接 upstream 使用，为 nil 时直连
+	ConnectTimeOut      time.Duration //unit: second
+	DeadLine            time.Duration //双向都没有数据的空闲超时，unit: second
+	HealthCheckInterval time.Duration //unit: second，<0 时不做主动健康检查
+	MaxFails            int           //连续连接失败多少次后暂停使用该 upstream，0 时为 DEFAULT_UPSTREAM_MAX_FAILS
+	FailTimeOut         time.Duration //连接失败后暂停使用的时间，到期后重新尝试，unit: second，0 时为 DEFAULT_UPSTREAM_FAIL_TIMEOUT
+	Logger              Logger
+	quit                chan struct{}
+	wg                  sync.WaitGroup
+}
+
+//upstreams: ["10.0.0.1:80", "10.0.0.2:80"]
+func NewTCPProxy(upstreams []string, balancer Balancer) *TCPProxy {
+	p := &TCPProxy{
+		Balancer:            balancer,
+		ConnectTimeOut:      DEFAULT_CONNECT_TIMEOUT,
+		DeadLine:            DEFAULT_DEADLINE,
+		HealthCheckInterval: DEFAULT_HEALTH_CHECK_INTERVAL,
+		MaxFails:            DEFAULT_UPSTREAM_MAX_FAILS,
+		FailTimeOut:         DEFAULT_UPSTREAM_FAIL_TIMEOUT,
+	}
+	for _, addr := range upstreams {
+		u := &Upstream{Addr: addr}
+		u.healthy.Set(SOCKET_OPEN)
+		p.upstreams = append(p.upstreams, u)
+	}
+	return p
+}
+
+func (p *TCPProxy) log() Logger {
+	return loggerOrNop(p.Logger)
+}
+
+//addr: "0.0.0.0:8080"
+func (p *TCPProxy) Start(addr string) error {
+	if len(p.upstreams) == 0 {
+		return ErrNoHealthyUpstream
+	}
+	if p.Balancer == nil {
+		p.Balancer = &RoundRobinBalancer{}
+	}
+	p.quit = make(chan struct{})
+	p.server.IBaseTCPServerHandle = p
+	p.server.Logger = p.Logger
+	if err := p.server.StartByAddr(addr); err != nil {
+		return err
+	}
+	if p.HealthCheckInterval >= 0 {
+		p.wg.Add(1)
+		go p.healthCheckLoop()
+	}
+	return nil
+}
+
+func (p *TCPProxy) Addr() net.Addr {
+	return p.server.Addr()
+}
+
+//关闭监听并停止健康检查，已建立的转发连接不受影响
+func (p *TCPProxy) Close() {
+	if p.server.closed.Get() == SOCKET_OPEN {
+		close(p.quit)
+		p.server.Close()
+		p.wg.Wait()
+	}
+}
+
+func (p *TCPProxy) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+func (p *TCPProxy) Stats() []UpstreamStats {
+	stats := make([]UpstreamStats, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		stats = append(stats, u.Stats())
+	}
+	return stats
+}
+
+func (p *TCPProxy) OnAccept(c net.Conn) {
+	go p.serve(c)
+}
+
+func (p *TCPProxy) serve(client net.Conn) {
+	defer client.Close()
+	u, upConn, err := p.dialUpstream(client.RemoteAddr())
+	if err != nil {
+		p.log().Log(LOG_LEVEL_WARN, "tcp proxy connect upstream failed", "client", client.RemoteAddr(), "err", err)
+		return
+	}
+	defer upConn.Close()
+
+	u.activeConns.Add(1)
+	defer u.activeConns.Add(-1)
+	p.log().Log(LOG_LEVEL_DEBUG, "tcp proxy session started", "client", client.RemoteAddr(), "upstream", u.Addr)
+
+	var lastActive AtomicInt64
+	lastActive.Set(time.Now().UnixNano())
+	done := make(chan struct{}, 2)
+	go p.pipe(upConn, client, &u.bytesSent, &lastActive, done)
+	go p.pipe(client, upConn, &u.bytesReceived, &lastActive, done)
+	<-done
+	<-done
+	p.log().Log(LOG_LEVEL_DEBUG, "tcp proxy session closed", "client", client.RemoteAddr(), "upstream", u.Addr)
+}
+
+//连接失败的 upstream 换下一个重试，连续失败 MaxFails 次后暂停使用 FailTimeOut
+func (p *TCPProxy) dialUpstream(client net.Addr) (*Upstream, net.Conn, error) {
+	var lastErr error = ErrNoHealthyUpstream
+	for i := 0; i < len(p.upstreams); i++ {
+		u := p.Balancer.Pick(p.upstreams, client)
+		if u == nil {
+			break
+		}
+		u.totalConns.Add(1)
+		ctx, cancel := connectContext(p.ConnectTimeOut)
+		conn, err := forwardDialer(p.Dialer).DialContext(ctx, "tcp", u.Addr)
+		cancel()
+		if err == nil {
+			u.fails.Set(0)
+			return u, conn, nil
+		}
+		u.failedConns.Add(1)
+		p.markFailed(u, err)
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+//src 读到 EOF 时只关闭 dst 的写端，另一个方向继续转发
+func (p *TCPProxy) pipe(dst, src net.Conn, counter *AtomicInt64, lastActive *AtomicInt64, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+	buf := tcpProxyBufferPool.Get().([]byte)
+	defer tcpProxyBufferPool.Put(buf)
+	idle := p.DeadLine * time.Second
+	for {
+		if idle > 0 {
+			src.SetReadDeadline(time.Unix(0, lastActive.Get()).Add(idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActive.Set(time.Now().UnixNano())
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				src.Close()
+				return
+			}
+			counter.Add(int64(n))
+		}
+		if err != nil {
+			if IsTimeoutError(err) && idle > 0 && time.Since(time.Unix(0, lastActive.Get())) < idle {
+				//另一个方向仍然活跃
+				continue
+			}
+			if err == io.EOF {
+				if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+					return
+				}
+			}
+			dst.Close()
+			src.Close()
+			return
+		}
+	}
+}
+
+//被动标记，不影响主动健康检查的结果，暂停期过后自动恢复
+func (p *TCPProxy) markFailed(u *Upstream, err error) {
+	maxFails := p.MaxFails
+	if maxFails <= 0 {
+		maxFails = DEFAULT_UPSTREAM_MAX_FAILS
+	}
+	if int(u.fails.Add(1)) < maxFails {
+		return
+	}
+	u.fails.Set(0)
+	failTimeOut := p.FailTimeOut * time.Second
+	if p.FailTimeOut <= 0 {
+		failTimeOut = DEFAULT_UPSTREAM_FAIL_TIMEOUT * time.Second
+	}
+	u.downUntil.Set(time.Now().Add(failTimeOut).UnixNano())
+	p.log().Log(LOG_LEVEL_WARN, "tcp proxy upstream suspended", "upstream", u.Addr, "duration", failTimeOut, "err", err)
+}
+
+//只改变主动检查的状态，被动失败的暂停期不受影响，到期后才重新使用
+func (p *TCPProxy) setHealthy(u *Upstream, healthy bool) {
+	if healthy {
+		if u.healthy.CompareAndSwap(SOCKET_CLOSED, SOCKET_OPEN) {
+			p.log().Log(LOG_LEVEL_INFO, "tcp proxy upstream up", "upstream", u.Addr)
+		}
+	} else if u.healthy.CompareAndSwap(SOCKET_OPEN, SOCKET_CLOSED) {
+		p.log().Log(LOG_LEVEL_WARN, "tcp proxy upstream down", "upstream", u.Addr)
+	}
+}
+
+func (p *TCPProxy) healthCheckLoop() {
+	defer p.wg.Done()
+	interval := p.HealthCheckInterval * time.Second
+	if interval <= 0 {
+		interval = DEFAULT_HEALTH_CHECK_INTERVAL * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.CheckHealth()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+//对所有 upstream 做一次 TCP 连接检查
+func (p *TCPProxy) CheckHealth() {
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_HEALTH_CHECK_TIMEOUT*time.Second)
+			defer cancel()
+			conn, err := forwardDialer(p.Dialer).DialContext(ctx, "tcp", u.Addr)
+			if err != nil {
+				p.setHealthy(u, false)
+				return
+			}
+			conn.Close()
+			p.setHealthy(u, true)
+		}(u)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,159 @@
+// tcp_proxy_test.go
+package gobase
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+//读到 EOF 后回复 "name:" + 收到的数据，用于验证半关闭
+func startNamedBackend(t *testing.T, name string) net.Listener {
+	return startNamedBackendOn(t, "127.0.0.1:0", name)
+}
+
+func startNamedBackendOn(t *testing.T, addr string, name string) net.Listener {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				conn.Write(append([]byte(name+":"), data...))
+			}()
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+func proxyRoundTrip(t *testing.T, addr string, msg string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(msg))
+	conn.(*net.TCPConn).CloseWrite()
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(resp)
+}
+
+func Test_TCPProxyRoundRobin(t *testing.T) {
+	a := startNamedBackend(t, "a")
+	b := startNamedBackend(t, "b")
+	p := NewTCPProxy([]string{a.Addr().String(), b.Addr().String()}, &RoundRobinBalancer{})
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if resp := proxyRoundTrip(t, p.Addr().String(), "1"); resp != "a:1" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if resp := proxyRoundTrip(t, p.Addr().String(), "2"); resp != "b:2" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	stats := p.Stats()
+	if stats[0].TotalConns != 1 || stats[1].TotalConns != 1 || stats[0].BytesSent != 1 || stats[0].BytesReceived != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func Test_TCPProxyFailover(t *testing.T) {
+	a := startNamedBackend(t, "a")
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead.Close()
+	p := NewTCPProxy([]string{dead.Addr().String(), a.Addr().String()}, &LeastConnBalancer{})
+	p.HealthCheckInterval = -1
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if resp := proxyRoundTrip(t, p.Addr().String(), "x"); resp != "a:x" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if p.Upstreams()[0].Healthy() {
+		t.Fatal("dead upstream should be marked unhealthy")
+	}
+	p.CheckHealth()
+	if p.Upstreams()[0].Healthy() || !p.Upstreams()[1].Healthy() {
+		t.Fatalf("unexpected health %+v", p.Stats())
+	}
+}
+
+func Test_ConsistentHashBalancer(t *testing.T) {
+	upstreams := []*Upstream{{Addr: "10.0.0.1:80"}, {Addr: "10.0.0.2:80"}, {Addr: "10.0.0.3:80"}}
+	for _, u := range upstreams {
+		u.healthy.Set(SOCKET_OPEN)
+	}
+	b := &ConsistentHashBalancer{}
+	client := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5000}
+	first := b.Pick(upstreams, client)
+	client.Port = 6000
+	if b.Pick(upstreams, client) != first {
+		t.Fatal("same client ip should map to the same upstream")
+	}
+	first.healthy.Set(SOCKET_CLOSED)
+	if u := b.Pick(upstreams, client); u == nil || u == first {
+		t.Fatal("unhealthy upstream should be skipped")
+	}
+}
+
+func Test_TCPProxyPassiveRecovery(t *testing.T) {
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := dead.Addr().String()
+	dead.Close()
+	p := NewTCPProxy([]string{addr}, nil)
+	p.HealthCheckInterval = -1
+	p.MaxFails = 2
+	p.FailTimeOut = 1
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	//没有可用的 upstream 时直接关闭客户端连接
+	failedRoundTrip := func() {
+		conn, err := net.Dial("tcp", p.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.ReadAll(conn)
+		conn.Close()
+	}
+	failedRoundTrip()
+	if !p.Upstreams()[0].Healthy() {
+		t.Fatal("upstream suspended before MaxFails")
+	}
+	failedRoundTrip()
+	if p.Upstreams()[0].Healthy() {
+		t.Fatal("upstream should be suspended after MaxFails")
+	}
+
+	//主动检查通过也不会提前结束暂停期
+	startNamedBackendOn(t, addr, "a")
+	p.CheckHealth()
+	if p.Upstreams()[0].Healthy() {
+		t.Fatal("active check should not end the suspension")
+	}
+	//暂停期过后重新尝试
+	time.Sleep(1100 * time.Millisecond)
+	if resp := proxyRoundTrip(t, p.Addr().String(), "y"); resp != "a:y" {
+		t.Fatalf("upstream not recovered, response %q", resp)
+	}
+}
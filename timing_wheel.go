@@ -18,6 +18,8 @@ type TimingWheel struct {
 	cs []chan struct{}
 
 	pos int
+
+	logger Logger
 }
 
 func NewTimingWheel(interval time.Duration, buckets int) *TimingWheel {
@@ -42,12 +44,26 @@ func NewTimingWheel(interval time.Duration, buckets int) *TimingWheel {
 	return w
 }
 
+func (w *TimingWheel) SetLogger(logger Logger) {
+	w.Lock()
+	w.logger = logger
+	w.Unlock()
+}
+
+func (w *TimingWheel) log() Logger {
+	w.Lock()
+	defer w.Unlock()
+	return loggerOrNop(w.logger)
+}
+
 func (w *TimingWheel) Stop() {
+	w.log().Log(LOG_LEVEL_DEBUG, "timing wheel stopped", "interval", w.interval, "buckets", len(w.cs))
 	close(w.quit)
 }
 
 func (w *TimingWheel) After(timeout time.Duration) <-chan struct{} {
 	if timeout >= w.maxTimeout {
+		w.log().Log(LOG_LEVEL_ERROR, "timing wheel timeout over maxtimeout", "timeout", timeout, "maxtimeout", w.maxTimeout)
 		panic("timeout too much, over maxtimeout")
 	}
 